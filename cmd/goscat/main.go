@@ -25,10 +25,19 @@ type item struct {
 
 func main() {
 	var width, height uint
+	var printer string
 	flag.UintVar(&width, "width", 0, "width")
 	flag.UintVar(&height, "height", 0, "height")
+	flag.StringVar(&printer, "printer", "", "print for a monochrome sixel printer (la50, ln03)")
 	flag.Parse()
 
+	var p *sixel.Printer
+	if printer != "" {
+		if p = sixel.LookupPrinter(printer); p == nil {
+			log.Fatalf("unknown printer %q", printer)
+		}
+	}
+
 	resp, err := http.Get("https://api.thecatapi.com/v1/images/search")
 	if err != nil {
 		log.Fatal(err)
@@ -68,9 +77,15 @@ func main() {
 	buf := bufio.NewWriter(os.Stdout)
 	defer buf.Flush()
 
-	enc := sixel.NewEncoder(buf)
-	enc.Dither = true
-	err = enc.Encode(img)
+	if p != nil {
+		enc := sixel.NewPrinterEncoder(buf, p)
+		enc.Dither = true
+		err = enc.Encode(img)
+	} else {
+		enc := sixel.NewEncoder(buf)
+		enc.Dither = true
+		err = enc.Encode(img)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
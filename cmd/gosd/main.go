@@ -1,44 +1,297 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mattn/go-sixel"
+	"github.com/soniakeys/quant/median"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
-func main() {
-	flag.Usage = func() {
-		fmt.Println("Usage of " + os.Args[0] + ": gosd [filename]")
-		flag.PrintDefaults()
+var (
+	fFormat = flag.String("format", "", "output format: png, jpeg, gif, bmp or tiff (default from the output name, else png)")
+	fInfo   = flag.Bool("info", false, "print the parameters, size and colors of each image instead of converting")
+	fOutput = flag.String("o", "", "output file; the arguments are then the input files")
+	fDelay  = flag.Int("delay", 10, "delay between frames of an animated GIF in 1/100 seconds")
+)
+
+// frame is an image read from an input.
+type frame struct {
+	name string
+	// n numbers the images of an input from 1
+	n   int
+	img image.Image
+	// format is the name of a format other than sixel, whose images have
+	// no header and info
+	format string
+	header sixel.Header
+	info   sixel.Info
+}
+
+// readFrames reads the image of a format other than sixel in r, or else
+// every sixel image in it, skipping text before and between them as a
+// terminal capture has.
+func readFrames(name string, r io.Reader) ([]frame, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
-	flag.Parse()
-	var img image.Image
-	err := sixel.NewDecoder(os.Stdin).Decode(&img)
+	if !bytes.HasPrefix(data, []byte("\x1bP")) && !bytes.HasPrefix(data, []byte{0x90}) {
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err == nil {
+			return []frame{{name: name, img: img, format: format}}, nil
+		}
+		if !errors.Is(err, image.ErrFormat) {
+			return nil, err
+		}
+	}
+	dec := sixel.NewDecoder(bytes.NewReader(data))
+	var frames []frame
+	for {
+		seg, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seg.Image != nil {
+			frames = append(frames, frame{name: name, n: len(frames) + 1, img: seg.Image, header: seg.Header, info: dec.Info()})
+		}
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no image found")
+	}
+	return frames, nil
+}
+
+func readFile(name string) ([]frame, error) {
+	if name == "-" {
+		return readFrames(name, os.Stdin)
+	}
+	f, err := os.Open(name)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return nil, err
 	}
+	defer f.Close()
+	return readFrames(name, f)
+}
 
-	if flag.NArg() == 0 {
-		err = png.Encode(os.Stdout, img)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+func printInfo(w io.Writer, f frame) {
+	b := f.img.Bounds()
+	if f.format != "" {
+		fmt.Fprintf(w, "%s: %s %dx%d\n", f.name, f.format, b.Dx(), b.Dy())
+		return
+	}
+	h, info := f.header, f.info
+	fmt.Fprintf(w, "%s: image %d\n", f.name, f.n)
+	fmt.Fprintf(w, "  parameters: P1=%d P2=%d P3=%d\n", h.P1, h.P2, h.P3)
+	if h.RasterAttributes {
+		fmt.Fprintf(w, "  raster attributes: aspect %d:%d, size %dx%d\n", h.Pan, h.Pad, h.Ph, h.Pv)
+	} else {
+		fmt.Fprintf(w, "  raster attributes: none\n")
+	}
+	fmt.Fprintf(w, "  painted size: %dx%d\n", info.Width, info.Height)
+	fmt.Fprintf(w, "  image size: %dx%d\n", b.Dx(), b.Dy())
+	defined, used := 0, 0
+	for _, r := range info.Registers {
+		if r.Defined {
+			defined++
 		}
+		if r.Used {
+			used++
+		}
+	}
+	fmt.Fprintf(w, "  registers: %d defined, %d used\n", defined, used)
+	for _, r := range info.Registers {
+		var flags []string
+		if r.Defined {
+			flags = append(flags, "defined")
+		}
+		if r.Used {
+			flags = append(flags, "used")
+		}
+		fmt.Fprintf(w, "    #%d #%02x%02x%02x %s\n", r.N, r.Color.R, r.Color.G, r.Color.B, strings.Join(flags, " "))
+	}
+	if pixels := b.Dx() * b.Dy(); pixels > 0 {
+		fmt.Fprintf(w, "  bytes: %d (%.3f per pixel)\n", info.Bytes, float64(info.Bytes)/float64(pixels))
 	} else {
-		f, err := os.Create(flag.Arg(0))
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+		fmt.Fprintf(w, "  bytes: %d\n", info.Bytes)
+	}
+}
+
+// outputFormat returns the format to write: the -format flag, or the one
+// matching the extension of name.
+func outputFormat(name string) (string, error) {
+	format := *fFormat
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+		if format == "" || name == "-" {
+			format = "png"
 		}
-		err = png.Encode(f, img)
+	}
+	switch format {
+	case "jpg":
+		format = "jpeg"
+	case "tif":
+		format = "tiff"
+	}
+	switch format {
+	case "png", "jpeg", "gif", "bmp", "tiff":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+// paletted returns img as a paletted image for GIF, quantized only if it
+// has more than 256 colors.
+func paletted(img image.Image) *image.Paletted {
+	if p, ok := img.(*image.Paletted); ok {
+		return p
+	}
+	b := img.Bounds()
+	if palette := colors(img, 256); palette != nil {
+		p := image.NewPaletted(b, palette)
+		draw.Draw(p, b, img, b.Min, draw.Src)
+		return p
+	}
+	p := image.NewPaletted(b, median.Quantizer(256).Quantize(make(color.Palette, 0, 256), img))
+	draw.FloydSteinberg.Draw(p, b, img, b.Min)
+	return p
+}
+
+// colors returns the colors of img, or nil if there are more than n.
+func colors(img image.Image, n int) color.Palette {
+	b := img.Bounds()
+	seen := make(map[color.NRGBA]bool)
+	var palette color.Palette
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			if seen[c] {
+				continue
+			}
+			if len(palette) == n {
+				return nil
+			}
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+	return palette
+}
+
+func encodeAnimation(w io.Writer, frames []frame) error {
+	g := &gif.GIF{}
+	for _, f := range frames {
+		p := paletted(f.img)
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, *fDelay)
+		g.Config.Width = max(g.Config.Width, p.Rect.Max.X)
+		g.Config.Height = max(g.Config.Height, p.Rect.Max.Y)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+func encode(w io.Writer, format string, img image.Image) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, nil)
+	case "gif":
+		return gif.Encode(w, paletted(img), nil)
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "tiff":
+		return tiff.Encode(w, img, nil)
+	}
+	return png.Encode(w, img)
+}
+
+// write writes the frames as an animated GIF, or else the first one.
+func write(w io.Writer, format string, frames []frame) error {
+	if len(frames) > 1 && format == "gif" {
+		return encodeAnimation(w, frames)
+	}
+	return encode(w, format, frames[0].img)
+}
+
+func run() error {
+	// Without -o the input is stdin and the argument names the output.
+	inputs, output := []string{"-"}, "-"
+	if *fOutput != "" || *fInfo {
+		if flag.NArg() > 0 {
+			inputs = flag.Args()
+		}
+		if *fOutput != "" {
+			output = *fOutput
+		}
+	} else if flag.NArg() > 0 {
+		output = flag.Arg(0)
+	}
+
+	var frames []frame
+	for _, name := range inputs {
+		f, err := readFile(name)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		frames = append(frames, f...)
+	}
+
+	if *fInfo {
+		for _, f := range frames {
+			printInfo(os.Stdout, f)
 		}
+		return nil
+	}
+
+	format, err := outputFormat(output)
+	if err != nil {
+		return err
+	}
+	if len(frames) > 1 && format != "gif" {
+		fmt.Fprintf(os.Stderr, "%d more images ignored; use -format gif to write an animation\n", len(frames)-1)
+	}
+
+	if output == "-" {
+		return write(os.Stdout, format, frames)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	err = write(f, format, frames)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("Usage of " + os.Args[0] + ": gosd [filename] < input, gosd -o filename [inputs] or gosd -info [inputs]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -14,7 +14,9 @@ import (
 	"time"
 
 	"github.com/mattn/go-sixel"
+	"github.com/mattn/go-sixel/detect"
 	tty "github.com/mattn/go-tty/v2"
+	"golang.org/x/term"
 )
 
 //go:embed public
@@ -52,7 +54,9 @@ func loadImage(fs embed.FS, n string) ([]byte, int) {
 	img = tmp
 
 	var buf bytes.Buffer
-	err = sixel.NewEncoder(&buf).Encode(img)
+	enc := sixel.NewEncoder(&buf)
+	enc.Profile = profile
+	err = enc.Encode(img)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -70,11 +74,24 @@ func reserveLines(t *tty.TTY, height int) int {
 
 var bg = color.RGBA64{0, 0, 0, 0xFFFF}
 
+// profile holds the limits reported by the terminal, if any.
+var profile *sixel.Profile
+
 func main() {
 	var img [4][]byte
 
-	if err := detectBackgroundColor(); err != nil {
-		log.Fatalf("DRCS Sixel not supported: %v", err)
+	// A terminal that cannot be queried may still show sixel images, so
+	// only give up when it replies without sixel support.
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		if caps, err := detect.Detect(); err == nil {
+			if !caps.Sixel {
+				log.Fatal("DRCS Sixel not supported by the terminal")
+			}
+			profile = caps.Profile()
+			if caps.Background != nil {
+				bg = color.RGBA64Model.Convert(caps.Background).(color.RGBA64)
+			}
+		}
 	}
 
 	height := 0
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mattn/go-sixel"
+)
+
+var (
+	fProfile = flag.String("profile", "", "also report what exceeds the limits of a terminal profile (e.g. vt340, xterm)")
+	fStrict  = flag.Bool("strict", false, "exit with status 1 on warnings as well as errors")
+)
+
+// lint prints the diagnostics for one file and reports whether it has
+// errors, or warnings when running strict.
+func lint(name string, profile *sixel.Profile) (bool, error) {
+	var r io.Reader
+	if name == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		r = f
+	}
+	diags, err := sixel.Validate(r, profile)
+	if err != nil {
+		return false, err
+	}
+	failed := false
+	for _, d := range diags {
+		fmt.Printf("%s:%s\n", name, d)
+		if !d.Warning || *fStrict {
+			failed = true
+		}
+	}
+	return failed, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("Usage of " + os.Args[0] + ": goslint [files]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var profile *sixel.Profile
+	if *fProfile != "" {
+		if profile = sixel.LookupProfile(*fProfile); profile == nil {
+			fmt.Fprintf(os.Stderr, "unknown profile %q\n", *fProfile)
+			os.Exit(2)
+		}
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 0
+	for _, name := range files {
+		failed, err := lint(name, profile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		if failed && status == 0 {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
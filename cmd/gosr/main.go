@@ -14,6 +14,8 @@ import (
 
 	"github.com/BurntSushi/graphics-go/graphics"
 	"github.com/mattn/go-sixel"
+	"github.com/mattn/go-sixel/detect"
+	"golang.org/x/term"
 )
 
 var (
@@ -112,11 +114,15 @@ func render(filename string) error {
 	enc := sixel.NewEncoder(os.Stdout)
 	enc.Dither = true
 	enc.Transparent = *fTransparent
+	enc.Profile = profile
 	return enc.Encode(img)
 }
 
 var bg = color.RGBA64{0, 0, 0, 0xFFFF}
 
+// profile holds the limits reported by the terminal, if any.
+var profile *sixel.Profile
+
 func main() {
 	flag.Usage = func() {
 		fmt.Println("Usage of " + os.Args[0] + ": gosr [images]")
@@ -128,9 +134,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	if !*fTransparent {
-		if err := detectBackgroundColor(); err != nil {
-			log.Fatalf("DRCS Sixel not supported: %v", err)
+	// A terminal that cannot be queried may still show sixel images, so
+	// only give up when it replies without sixel support.
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		if caps, err := detect.Detect(); err == nil {
+			if !caps.Sixel {
+				log.Fatal("DRCS Sixel not supported by the terminal")
+			}
+			profile = caps.Profile()
+			if !*fTransparent && caps.Background != nil {
+				bg = color.RGBA64Model.Convert(caps.Background).(color.RGBA64)
+			}
 		}
 	}
 
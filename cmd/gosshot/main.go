@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/mattn/go-sixel/internal/capture"
+	"github.com/mattn/go-sixel/vt"
+)
+
+var (
+	fAt     = flag.Float64("at", -1, "render the screen at this time in seconds of a cast (default the end)")
+	fCols   = flag.Int("cols", 0, "columns of the terminal (default from the cast, else 80)")
+	fRows   = flag.Int("rows", 0, "rows of the terminal (default from the cast, else 24)")
+	fCell   = flag.String("cell", "10x20", "size of a character cell in pixels")
+	fOutput = flag.String("o", "", "output PNG file (default stdout)")
+)
+
+func run() error {
+	var cw, ch int
+	if _, err := fmt.Sscanf(*fCell, "%dx%d", &cw, &ch); err != nil || cw <= 0 || ch <= 0 {
+		return fmt.Errorf("invalid cell size %q", *fCell)
+	}
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 0 && flag.Arg(0) != "-" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	rec, err := capture.Read(r)
+	if err != nil {
+		return err
+	}
+	data := rec.Data
+	if *fAt >= 0 {
+		if data, err = rec.Until(*fAt); err != nil {
+			return err
+		}
+	}
+
+	cols, rows := 80, 24
+	if rec.Width > 0 && rec.Height > 0 {
+		cols, rows = rec.Width, rec.Height
+	}
+	if *fCols > 0 {
+		cols = *fCols
+	}
+	if *fRows > 0 {
+		rows = *fRows
+	}
+	term := vt.New(cols, rows, cw, ch)
+	term.Write(data)
+
+	if *fOutput == "" {
+		return png.Encode(os.Stdout, term.Image())
+	}
+	f, err := os.Create(*fOutput)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, term.Image())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("Usage of " + os.Args[0] + ": gosshot [typescript|cast]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
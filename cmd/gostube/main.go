@@ -20,7 +20,9 @@ import (
 	"time"
 
 	"github.com/mattn/go-sixel"
+	"github.com/mattn/go-sixel/detect"
 	tty "github.com/mattn/go-tty/v2"
+	"golang.org/x/term"
 )
 
 type probeData struct {
@@ -41,19 +43,24 @@ type videoMeta struct {
 }
 
 var (
-	fFPS    = flag.Float64("fps", 0, "Playback FPS. Defaults to the source FPS")
-	fWidth  = flag.Int("width", 0, "Resize width in pixels")
-	fHeight = flag.Int("height", 0, "Resize height in pixels")
-	fColors = flag.Int("colors", 64, "Palette size for sixel encoding")
-	fDither = flag.Bool("dither", false, "Enable dithering")
-	fLoop   = flag.Bool("loop", false, "Loop playback")
-	fMute   = flag.Bool("mute", false, "Disable audio playback")
-	fFormat = flag.String("format", "bv*[height<=480]+ba/b[height<=480]/b", "yt-dlp format selector")
+	fFPS     = flag.Float64("fps", 0, "Playback FPS. Defaults to the source FPS")
+	fWidth   = flag.Int("width", 0, "Resize width in pixels")
+	fHeight  = flag.Int("height", 0, "Resize height in pixels")
+	fColors  = flag.Int("colors", 0, "Palette size for sixel encoding (default the color registers of the terminal up to 256, else 64)")
+	fDither  = flag.Bool("dither", false, "Enable dithering")
+	fLoop    = flag.Bool("loop", false, "Loop playback")
+	fMute    = flag.Bool("mute", false, "Disable audio playback")
+	fProfile = flag.String("profile", "", "Terminal profile limiting colors and size (vt340, xterm, mlterm, foot, wezterm, windows-terminal, mintty); by default the limits the terminal reports")
+	fFormat  = flag.String("format", "bv*[height<=480]+ba/b[height<=480]/b", "yt-dlp format selector")
 )
 
 // Path to ffplay used for audio playback, empty when audio is disabled.
 var audioPlayer string
 
+// Terminal limits selected with -profile or reported by the terminal, nil
+// when neither is known.
+var profile *sixel.Profile
+
 func main() {
 	flag.Usage = func() {
 		fmt.Println("Usage of " + os.Args[0] + ": gostube [options] url")
@@ -85,7 +92,18 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	if *fProfile != "" {
+		if profile = sixel.LookupProfile(*fProfile); profile == nil {
+			log.Fatalf("unknown profile %q", *fProfile)
+		}
+	} else if term.IsTerminal(int(os.Stdout.Fd())) {
+		// use the color registers and geometry the terminal reports
+		if caps, err := detect.Detect(); err == nil {
+			profile = caps.Profile()
+		}
+	}
 	width, height := targetSize(meta.Width, meta.Height, *fWidth, *fHeight)
+	width, height = fitProfile(width, height, profile)
 	fps := *fFPS
 	if fps <= 0 {
 		fps = meta.FPS
@@ -319,7 +337,8 @@ func play(ctx context.Context, videoURL, audioURL string, width, height int, fps
 		enc.Dither = *fDither
 		enc.Width = width
 		enc.Height = height
-		enc.Colors = *fColors
+		enc.Colors = paletteSize(profile)
+		enc.Profile = profile
 		free <- &slot{buf: buf, enc: enc}
 	}
 	frames := make(chan frame, pipelineDepth)
@@ -591,3 +610,32 @@ func targetSize(srcW, srcH, dstW, dstH int) (int, int) {
 		return srcW, srcH
 	}
 }
+
+// paletteSize returns the palette size to encode frames with: -colors if
+// set, else the color registers of profile up to 256, else 64.
+func paletteSize(profile *sixel.Profile) int {
+	if *fColors > 0 {
+		return *fColors
+	}
+	if profile != nil && profile.Colors > 0 {
+		return min(profile.Colors, 256)
+	}
+	return 64
+}
+
+// fitProfile scales width and height down, keeping the aspect ratio, so the
+// frame fits in the maximum sixel geometry of profile.
+func fitProfile(width, height int, profile *sixel.Profile) (int, int) {
+	if profile == nil {
+		return width, height
+	}
+	if profile.MaxWidth > 0 && width > profile.MaxWidth {
+		height = int(math.Round(float64(height) * float64(profile.MaxWidth) / float64(width)))
+		width = profile.MaxWidth
+	}
+	if profile.MaxHeight > 0 && height > profile.MaxHeight {
+		width = int(math.Round(float64(width) * float64(profile.MaxHeight) / float64(height)))
+		height = profile.MaxHeight
+	}
+	return width, height
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/mattn/go-sixel"
+	"github.com/mattn/go-sixel/internal/capture"
+)
+
+var (
+	fPrefix = flag.String("prefix", "sixel", "prefix of the PNG files written")
+	fStrict = flag.Bool("strict", false, "stop at the first malformed sixel sequence instead of skipping it")
+)
+
+func writePNG(name string, seg *sixel.Segment) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, seg.Image)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("Usage of " + os.Args[0] + ": gosx [typescript|cast]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 0 && flag.Arg(0) != "-" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+	rec, err := capture.Read(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	dec := sixel.NewDecoder(bytes.NewReader(rec.Data))
+	dec.Lenient = !*fStrict
+	n, skipped := 0, 0
+	for {
+		seg, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// images beyond the limits, or malformed unless -strict, are
+			// skipped and the next one is read
+			var le *sixel.LimitError
+			var se *sixel.SyntaxError
+			if !errors.As(err, &le) && (*fStrict || !errors.As(err, &se)) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprintln(os.Stderr, "skipped image:", err)
+			skipped++
+			continue
+		}
+		if seg.Image == nil {
+			continue
+		}
+		n++
+		name := fmt.Sprintf("%s-%03d.png", *fPrefix, n)
+		if err := writePNG(name, seg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if t, err := rec.Time(seg.Offset); err == nil {
+			fmt.Printf("%s\t%.6f\n", name, t)
+		} else {
+			fmt.Println(name)
+		}
+	}
+	if skipped > 0 {
+		os.Exit(1)
+	}
+}
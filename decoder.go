@@ -0,0 +1,1296 @@
+package sixel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math/bits"
+)
+
+// Decoder decode sixel format into image
+type Decoder struct {
+	r  io.Reader
+	br *bufio.Reader
+
+	// Paletted, if true, makes Decode return an *image.Paletted whose
+	// palette mirrors the color registers: index n holds register n as last
+	// defined, followed by a transparent entry for pixels that were never
+	// painted. Images needing more than 256 entries are returned as
+	// *image.NRGBA with the same colors.
+	Paletted bool
+
+	// AspectRatio, if true, stretches the image vertically by the pixel
+	// aspect ratio from the raster attributes ("Pan;Pad) or, without them,
+	// the DCS P1 parameter, so it looks as on a terminal drawing
+	// non-square pixels. The terms of the ratio are clamped to 10, and the
+	// stretched image must keep within the limits below. By default each
+	// sixel pixel becomes one image pixel.
+	AspectRatio bool
+
+	// Background is the color unpainted pixels inside the raster area are
+	// filled with when the DCS P2 parameter is 0 or 2. If nil, the color of
+	// register 0 is used, as a terminal would. With P2=1 unpainted pixels
+	// are left transparent.
+	Background color.Color
+
+	// MaxWidth, MaxHeight and MaxPixels limit the size of the image and
+	// MaxColorRegisters the number of color registers it may use, so that
+	// untrusted input cannot exhaust memory. Decode returns a *LimitError
+	// when one is exceeded. Zero values select DefaultMaxWidth,
+	// DefaultMaxHeight, DefaultMaxPixels and DefaultMaxColorRegisters.
+	MaxWidth          int
+	MaxHeight         int
+	MaxPixels         int
+	MaxColorRegisters int
+
+	// Lenient, if true, makes Decode skip what it cannot decode the way
+	// terminals do instead of returning a *SyntaxError: unknown tokens,
+	// repeats of non-sixel characters, undefined color registers (painted
+	// black), color introducers missing coordinates (read as 0) and bytes
+	// other than a DCS before the image. An escape sequence inside the
+	// image ends it. Errors that cannot be skipped, such as a *LimitError,
+	// are still returned, along with the image painted so far.
+	Lenient bool
+
+	// Progress, if not nil, is called during Decode after each graphics new
+	// line (-) and at the end of the image with the image decoded so far
+	// and the rectangle painted since the previous call, so images can be
+	// shown as they arrive. img is only valid during the call and is
+	// neither filled with the background nor stretched by AspectRatio.
+	Progress func(img image.Image, dirty image.Rectangle)
+
+	// Scale, if 2, 4 or 8, decodes the image at 1/Scale of its size, each
+	// output pixel averaging a Scale x Scale block of sixel pixels, for
+	// thumbnails. Only one band of sixels is held at full size. Scaled
+	// images are always *image.NRGBA, so Paletted is ignored, and Progress
+	// is not called. Other values above 1 are rounded down to one of these.
+	Scale int
+
+	// noFill leaves unpainted pixels transparent regardless of P2
+	noFill bool
+
+	cr     *countingReader
+	header Header
+	info   Info
+	// redefined is set if the last image defined a register after painting
+	// with it, which Paletted cannot show
+	redefined bool
+	// done is set once Next has returned the last segment
+	done bool
+}
+
+// Header holds the parameters of a sixel image: those of the DCS introducer
+// (ESC P P1;P2;P3 q) and the raster attributes ("Pan;Pad;Ph;Pv).
+type Header struct {
+	// P1 selects the pixel aspect ratio, P2 the treatment of unpainted
+	// pixels (0 and 2 background, 1 transparent) and P3 the horizontal
+	// grid size.
+	P1, P2, P3 int
+
+	// RasterAttributes reports whether the image had raster attributes,
+	// in which case Pan/Pad is its pixel aspect ratio and Ph x Pv its size.
+	RasterAttributes bool
+	Pan, Pad, Ph, Pv int
+}
+
+// Header returns the header of the image last read by Decode.
+func (e *Decoder) Header() Header {
+	return e.header
+}
+
+// Info describes how the image last read by Decode was drawn.
+type Info struct {
+	// Width and Height are the extent painted with sixels, which may
+	// differ from the size declared by the raster attributes.
+	Width, Height int
+
+	// Registers lists, in increasing order, the color registers the image
+	// defined or painted with.
+	Registers []Register
+
+	// Bytes is the length of the image in the stream, from its DCS
+	// introducer to its string terminator.
+	Bytes int64
+}
+
+// Register is a color register of an image.
+type Register struct {
+	// N is the register number.
+	N int
+
+	// Color is the color the register held at the end of the image.
+	Color color.NRGBA
+
+	// Defined reports whether the image defined the register, rather than
+	// using the color a terminal starts with.
+	Defined bool
+
+	// Used reports whether the image painted with the register.
+	Used bool
+}
+
+// Info returns how the image last read by Decode was drawn.
+func (e *Decoder) Info() Info {
+	return e.info
+}
+
+// NewDecoder return new instance of Decoder
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+func init() {
+	image.RegisterFormat("sixel", "\x1bP", Decode, DecodeConfig)
+	image.RegisterFormat("sixel", "\x90", Decode, DecodeConfig)
+}
+
+// Decode reads the first sixel image from r. It is registered with the
+// image package for the format name "sixel".
+func Decode(r io.Reader) (image.Image, error) {
+	var img image.Image
+	if err := NewDecoder(r).Decode(&img); err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return img, nil
+}
+
+// DecodeConfig returns the color model and size of the first sixel image in
+// r without painting it. The size is the one declared by the raster
+// attributes; only when they are missing is the sixel data scanned for the
+// painted extent.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	cr := &countingReader{r: r}
+	d := decoder{br: bufio.NewReader(cr), cr: cr, measure: true, config: true, limits: (&Decoder{}).limits()}
+	found, err := d.readIntroducer()
+	if err == nil && !found {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = d.readData()
+	}
+	if err != nil {
+		return image.Config{}, err
+	}
+	w, h := d.size()
+	return image.Config{ColorModel: color.NRGBAModel, Width: w, Height: h}, nil
+}
+
+// vt340Colors are the 16 predefined color registers of VT340.
+var vt340Colors = [16]color.NRGBA{
+	sixelRGB(0, 0, 0),
+	sixelRGB(20, 20, 80),
+	sixelRGB(80, 13, 13),
+	sixelRGB(20, 80, 20),
+	sixelRGB(80, 20, 80),
+	sixelRGB(20, 80, 80),
+	sixelRGB(80, 80, 20),
+	sixelRGB(53, 53, 53),
+	sixelRGB(26, 26, 26),
+	sixelRGB(33, 33, 60),
+	sixelRGB(60, 26, 26),
+	sixelRGB(33, 60, 33),
+	sixelRGB(60, 33, 60),
+	sixelRGB(33, 60, 60),
+	sixelRGB(60, 60, 33),
+	sixelRGB(80, 80, 80),
+}
+
+// maxColorRegisters bounds the color register numbers accepted, as
+// paletted mode stores them in 16 bits.
+const maxColorRegisters = 1<<16 - 1
+
+// Errors wrapped by SyntaxError.
+var (
+	ErrHeader         = errors.New("invalid format: illegal header")
+	ErrToken          = errors.New("invalid format: illegal data tokens")
+	ErrRepeat         = errors.New("invalid format: illegal repeating data tokens")
+	ErrColor          = errors.New("invalid format: illegal color specifier")
+	ErrUndefinedColor = errors.New("invalid format: undefined color number")
+)
+
+// SyntaxError reports invalid sixel data and where it was found.
+type SyntaxError struct {
+	// Offset is the byte offset of the offending token in the stream.
+	Offset int64
+	// Band and Column are the active position when it was read: the
+	// six-pixel band counted from 0 and the pixel column.
+	Band, Column int
+	// Err is ErrHeader, ErrToken, ErrRepeat, ErrColor or
+	// ErrUndefinedColor, possibly wrapped with details.
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("sixel: %v at offset %d (band %d, column %d)", e.Err, e.Offset, e.Band, e.Column)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// fail handles invalid data err found at offset off: it returns a
+// *SyntaxError, or nil if decoding leniently, in which case the data is
+// skipped.
+func (d *decoder) fail(off int64, err error) error {
+	if d.lint != nil {
+		d.lint.errorf(off, "%v", err)
+	}
+	if d.lenient {
+		return nil
+	}
+	return d.syntaxError(off, err)
+}
+
+// syntaxError returns a *SyntaxError for err found at offset off.
+func (d *decoder) syntaxError(off int64, err error) error {
+	return &SyntaxError{Offset: off, Band: d.y / 6, Column: d.x, Err: err}
+}
+
+// Default resource limits of Decoder.
+const (
+	DefaultMaxWidth          = 1 << 14
+	DefaultMaxHeight         = 1 << 14
+	DefaultMaxPixels         = 1 << 25
+	DefaultMaxColorRegisters = 1 << 12
+)
+
+// LimitError reports that an image exceeds a resource limit of Decoder.
+type LimitError struct {
+	// Limit names the limit: "width", "height", "pixels" or
+	// "color registers".
+	Limit string
+	// Value is the value that exceeds Max.
+	Value, Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("sixel: image %s %d exceeds limit %d", e.Limit, e.Value, e.Max)
+}
+
+// limits holds the resource limits of a decoder.
+type limits struct {
+	width, height, pixels, colors int
+}
+
+// limits returns the resource limits of e with defaults filled in.
+func (e *Decoder) limits() limits {
+	l := limits{e.MaxWidth, e.MaxHeight, e.MaxPixels, e.MaxColorRegisters}
+	if l.width <= 0 {
+		l.width = DefaultMaxWidth
+	}
+	if l.height <= 0 {
+		l.height = DefaultMaxHeight
+	}
+	if l.pixels <= 0 {
+		l.pixels = DefaultMaxPixels
+	}
+	if l.colors <= 0 {
+		l.colors = DefaultMaxColorRegisters
+	}
+	l.colors = min(l.colors, maxColorRegisters)
+	return l
+}
+
+// maxParam is where numeric parameters saturate.
+const maxParam = 1<<31 - 1
+
+// Decode do decoding from image
+func (e *Decoder) Decode(img *image.Image) error {
+	_, err := e.decode(img, nil)
+	return err
+}
+
+// DecodeInto decodes the next image and paints it onto dst with its top
+// left corner at at, as a terminal would onto the screen: pixels the image
+// does not paint are left untouched. Images with a DCS P2 parameter of 0 or
+// 2 paint their raster area with the background color like Decode fills it;
+// with P2=1 only the sixels drawn change dst. It returns io.EOF if the input
+// holds no further image.
+func (e *Decoder) DecodeInto(dst draw.Image, at image.Point) error {
+	var img image.Image
+	_, err := e.decode(&img, nil)
+	if img != nil {
+		// painted pixels are opaque and the rest transparent, so drawing
+		// over dst replaces just the painted ones
+		b := img.Bounds()
+		draw.Draw(dst, b.Add(at), img, b.Min, draw.Over)
+	} else if err == nil {
+		err = io.EOF
+	}
+	return err
+}
+
+// decode decodes the next image into img, collecting the text before it
+// into text if not nil, and returns the offset of its introducer. img is
+// left alone if the input ended before an image.
+func (e *Decoder) decode(img *image.Image, text *[]byte) (int64, error) {
+	if e.br == nil {
+		e.cr = &countingReader{r: e.r}
+		e.br = bufio.NewReader(e.cr)
+	}
+	d := decoder{
+		br:       e.br,
+		cr:       e.cr,
+		text:     text,
+		lenient:  e.Lenient,
+		noFill:   e.noFill,
+		progress: e.Progress,
+		paletted: e.Paletted,
+		bg:       e.Background,
+		limits:   e.limits(),
+	}
+	if e.Scale > 1 {
+		d.scale = 1 << min(bits.Len(uint(e.Scale))-1, 3)
+		d.paletted = false
+		d.progress = nil
+		d.accY = -1
+	}
+	found, err := d.readIntroducer()
+	if err != nil || !found {
+		return d.start, err
+	}
+	err = d.readData()
+	e.header = d.header()
+	e.info = d.info(e.offset() - d.start)
+	e.redefined = d.redefined
+	if err != nil {
+		if e.Lenient && d.dw > 0 && d.dh > 0 {
+			// return what was painted before the error
+			*img = d.image()
+		}
+		return d.start, err
+	}
+	if e.AspectRatio {
+		num, den := d.aspect()
+		w, h := d.size()
+		if err := d.checkSize(w, (h*num+den/2)/den); err != nil {
+			if e.Lenient {
+				*img = d.image()
+			}
+			return d.start, err
+		}
+		*img = stretch(d.image(), num, den)
+		return d.start, nil
+	}
+	*img = d.image()
+	return d.start, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// offset returns the offset of the next byte to be decoded.
+func (e *Decoder) offset() int64 {
+	if e.br == nil {
+		return 0
+	}
+	return e.cr.n - int64(e.br.Buffered())
+}
+
+// pos returns the offset of the next byte to be read.
+func (d *decoder) pos() int64 {
+	if d.cr == nil {
+		return 0
+	}
+	return d.cr.n - int64(d.br.Buffered())
+}
+
+// decoder holds the state of decoding one sixel image. Pixels are painted
+// straight into a pixel slice that grows as needed: NRGBA colors, or in
+// paletted mode 16-bit register numbers plus one, leaving 0 for unpainted
+// pixels.
+type decoder struct {
+	br *bufio.Reader
+	cr *countingReader
+
+	// text collects the bytes between images if not nil
+	text *[]byte
+	// lenient skips what cannot be decoded instead of failing
+	lenient bool
+	// noFill leaves unpainted pixels transparent regardless of P2
+	noFill bool
+	// lint collects diagnostics for Validate if not nil
+	lint *linter
+	// scale is the Scale divisor; if above 1 the canvas holds one band,
+	// which is reduced into out by flushBand
+	scale int
+	scaler
+	// progress is called with the area painted in dirty
+	progress func(image.Image, image.Rectangle)
+	dirty    image.Rectangle
+	// start is the offset of the DCS introducer
+	start int64
+
+	paletted bool
+	// measure, if true, only tracks the extent of the image without
+	// painting it
+	measure bool
+	// config, if true, stops at raster attributes declaring the size
+	config bool
+	// configured background color, nil for register 0
+	bg color.Color
+	limits
+	// bytes per canvas pixel
+	bpp int
+
+	// color registers, whether they have been defined, by the image or
+	// as predefined colors, and whether the image defined or painted with
+	// them
+	colors  []color.NRGBA
+	defined []bool
+	own     []bool
+	used    []bool
+	// redefined is set once a register painted with gets another color,
+	// or is defined after painting with its initial one
+	redefined bool
+	// highest register defined by the image or selected for painting
+	top int
+	// selected register
+	reg int
+	// pen holds the canvas pixel value of the selected register
+	pen [4]byte
+
+	// DCS parameters P1, P2 and P3
+	p [3]int
+	// raster attributes, if raster is set
+	raster           bool
+	pan, pad, ph, pv int
+
+	// active position
+	x, y int
+	// painted extent
+	dw, dh int
+
+	// canvas
+	pix    []byte
+	stride int
+	w, h   int
+}
+
+// readIntroducer skips to the next DCS and reads its parameters up to the
+// final 'q'. It reports false if the input ended before a DCS. If d.text is
+// not nil, the bytes skipped are appended to it, including escape sequences
+// and DCS strings other than sixel, which are otherwise errors unless
+// decoding leniently. A 0x90 byte continuing a UTF-8 character is text, not
+// an 8-bit DCS.
+func (d *decoder) readIntroducer() (bool, error) {
+	var raw []byte
+	// continuation bytes expected to complete a UTF-8 character
+	cont := 0
+	for {
+		c, err := d.br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return false, err
+		}
+		d.start = d.pos() - 1
+		switch {
+		case c == 0x90 && cont == 0:
+			raw = append(raw[:0], c)
+		case c == 0x1b:
+			cont = 0
+			c, err = d.br.ReadByte()
+			if err == nil && c == 'P' {
+				raw = append(raw[:0], 0x1b, c)
+				break
+			}
+			if d.text == nil && !d.lenient {
+				if err != nil {
+					return false, err
+				}
+				return false, d.syntaxError(d.pos()-1, ErrHeader)
+			}
+			if d.text != nil {
+				*d.text = append(*d.text, 0x1b)
+			}
+			if err == nil {
+				// the byte may start the next sequence
+				d.br.UnreadByte()
+			} else if err != io.EOF {
+				return false, err
+			}
+			continue
+		default:
+			if d.text != nil {
+				*d.text = append(*d.text, c)
+			}
+			switch {
+			case c >= 0xc2 && c <= 0xdf:
+				cont = 1
+			case c >= 0xe0 && c <= 0xef:
+				cont = 2
+			case c >= 0xf0 && c <= 0xf4:
+				cont = 3
+			case c&0xc0 == 0x80 && cont > 0:
+				cont--
+			default:
+				cont = 0
+			}
+			continue
+		}
+
+		// DCS parameters up to the final byte
+		d.p = [3]int{}
+		i := 0
+		for {
+			c, err = d.br.ReadByte()
+			if err != nil {
+				break
+			}
+			raw = append(raw, c)
+			if c == ';' {
+				i++
+				continue
+			}
+			if c < '0' || c > '9' {
+				break
+			}
+			switch {
+			case i >= len(d.p):
+			case d.p[i] < maxParam/10:
+				d.p[i] = d.p[i]*10 + int(c-'0')
+			default:
+				d.p[i] = maxParam
+			}
+		}
+		if err == nil && c == 'q' {
+			if d.lint != nil {
+				d.lint.begin(d.start, raw[0] == 0x90)
+			}
+			return true, nil
+		}
+		if d.text == nil && !d.lenient {
+			if err != nil {
+				return false, err
+			}
+			return false, d.syntaxError(d.pos()-1, ErrHeader)
+		}
+		if err == nil && (c == 0x1b || c == 0x90) {
+			// the byte may start the next sequence
+			d.br.UnreadByte()
+			raw = raw[:len(raw)-1]
+		}
+		if d.text != nil {
+			*d.text = append(*d.text, raw...)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return false, err
+		}
+	}
+}
+
+// readNum reads a decimal parameter and the byte following it. Missing
+// parameters read as 0 and large ones saturate.
+func (d *decoder) readNum() (int, byte, error) {
+	n := 0
+	for {
+		c, err := d.br.ReadByte()
+		if err != nil {
+			return n, 0, err
+		}
+		if c < '0' || c > '9' {
+			return n, c, nil
+		}
+		if n < maxParam/10 {
+			n = n*10 + int(c-'0')
+		} else {
+			n = maxParam
+		}
+	}
+}
+
+// readParams reads parameters separated by ';' into params, skipping any
+// beyond len(params), and returns how many were stored and the byte
+// following them.
+func (d *decoder) readParams(params []int) (int, byte, error) {
+	for i := range params {
+		n, c, err := d.readNum()
+		if err != nil {
+			return i, c, err
+		}
+		params[i] = n
+		if c != ';' {
+			return i + 1, c, nil
+		}
+	}
+	for {
+		_, c, err := d.readNum()
+		if err != nil || c != ';' {
+			return len(params), c, err
+		}
+	}
+}
+
+// readData decodes sixel data up to the string terminator or the end of the
+// input.
+func (d *decoder) readData() error {
+	d.colors = append(d.colors[:0], vt340Colors[:]...)
+	d.defined = make([]bool, len(d.colors))
+	for i := range d.defined {
+		d.defined[i] = true
+	}
+	d.own = make([]bool, len(d.colors))
+	d.used = make([]bool, len(d.colors))
+	d.bpp = 4
+	if d.paletted {
+		d.bpp = 2
+	}
+	d.top = len(vt340Colors) - 1
+	d.selectColor(0)
+
+	if d.progress != nil {
+		defer d.report()
+	}
+
+	var params [5]int
+	c, err := d.br.ReadByte()
+	for {
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+				if d.lint != nil {
+					d.lint.end(d, -1, false)
+				}
+			}
+			return err
+		}
+		switch {
+		case c >= '?' && c <= '~':
+			if err := d.paint(c-'?', 1); err != nil {
+				return err
+			}
+		case c == '!':
+			// DECGRI (!Pn): Graphics Repeat Introducer
+			off := d.pos() - 1
+			var n int
+			n, c, err = d.readNum()
+			if err != nil {
+				continue
+			}
+			if c < '?' || c > '~' {
+				if err := d.fail(off, fmt.Errorf("%w '!%d%c'", ErrRepeat, n, c)); err != nil {
+					return err
+				}
+				// the repeat is dropped and c read as the next token
+				continue
+			}
+			if n == 0 && d.lint != nil {
+				d.lint.warnf(off, "repeat count of zero")
+			}
+			// terminals read a count of zero as one
+			if err := d.paint(c-'?', max(n, 1)); err != nil {
+				return err
+			}
+		case c == '#':
+			// DECGCI (#Pc;Pu;Px;Py;Pz): Graphics Color Introducer
+			off := d.pos() - 1
+			var n int
+			n, c, err = d.readParams(params[:])
+			if err != nil {
+				continue
+			}
+			if d.lint != nil {
+				d.lint.color(off, params[:n])
+			}
+			if err := d.setColor(params[:n]); err != nil {
+				if _, ok := err.(*LimitError); ok {
+					return err
+				}
+				return d.syntaxError(off, err)
+			}
+			continue
+		case c == '"':
+			// DECGRA ("Pan;Pad;Ph;Pv): Set Raster Attributes
+			var n int
+			n, c, err = d.readParams(params[:4])
+			if err != nil {
+				continue
+			}
+			if n >= 2 {
+				d.raster = true
+				d.pan, d.pad = params[0], params[1]
+				d.ph, d.pv = 0, 0
+				if n >= 4 {
+					d.ph, d.pv = params[2], params[3]
+					if err := d.checkSize(d.ph, d.pv); err != nil {
+						return err
+					}
+					if d.config && d.ph > 0 && d.pv > 0 {
+						return nil
+					}
+				}
+			}
+			continue
+		case c == '$':
+			// DECGCR ($): Graphics Carriage Return
+			d.x = 0
+		case c == '-':
+			// DECGNL (-): Graphics Next Line
+			if d.scale > 1 {
+				d.flushBand()
+			}
+			d.x = 0
+			d.y += 6
+			if d.progress != nil {
+				d.report()
+			}
+		case c == 0x9c:
+			if d.lint != nil {
+				d.lint.end(d, d.pos()-1, true)
+			}
+			return nil
+		case c == 0x1b:
+			c, err = d.br.ReadByte()
+			if err == nil && c == '\\' {
+				if d.lint != nil {
+					d.lint.end(d, d.pos()-2, false)
+				}
+				return nil
+			}
+			if err != nil {
+				continue
+			}
+			if err := d.fail(d.pos()-2, ErrToken); err != nil {
+				return err
+			}
+			// As on a terminal, any escape sequence ends the image.
+			d.br.UnreadByte()
+			if d.lint != nil {
+				d.lint.end(d, -1, false)
+			}
+			return nil
+		case c < 0x20:
+			// C0 controls are ignored inside DCS.
+		default:
+			if err := d.fail(d.pos()-1, fmt.Errorf("%w %q", ErrToken, c)); err != nil {
+				return err
+			}
+		}
+		c, err = d.br.ReadByte()
+	}
+}
+
+// setColor selects a color register, defining it first if color
+// coordinates are given.
+func (d *decoder) setColor(params []int) error {
+	nc := params[0]
+	if nc >= d.limits.colors {
+		return &LimitError{"color registers", nc + 1, d.limits.colors}
+	}
+	if len(params) > 1 {
+		if len(params) != 5 {
+			if !d.lenient {
+				return fmt.Errorf("%w '#%d;%d'", ErrColor, nc, params[1])
+			}
+			// missing coordinates read as 0
+			var full [5]int
+			copy(full[:], params)
+			params = full[:]
+		}
+		d.register(nc)
+		r, g, b := uint(params[2]), uint(params[3]), uint(params[4])
+		old := d.colors[nc]
+		if params[1] == 1 {
+			d.colors[nc] = sixelHLS(r, g, b)
+		} else {
+			d.colors[nc] = sixelRGB(r, g, b)
+		}
+		// pixels painted before the image first defined the register
+		// show the color the terminal held
+		if d.used[nc] && (d.colors[nc] != old || !d.own[nc]) {
+			d.redefined = true
+		}
+		d.defined[nc] = true
+		d.own[nc] = true
+	}
+	if nc >= len(d.colors) || !d.defined[nc] {
+		if !d.lenient {
+			return fmt.Errorf("%w %d", ErrUndefinedColor, nc)
+		}
+		// Terminals paint with whatever the register holds; here that is
+		// black, as for undefined registers in paletted mode.
+		d.register(nc)
+		d.colors[nc] = color.NRGBA{0, 0, 0, 0xFF}
+	}
+	d.selectColor(nc)
+	return nil
+}
+
+// register makes room for color register nc.
+func (d *decoder) register(nc int) {
+	for nc >= len(d.colors) {
+		d.colors = append(d.colors, color.NRGBA{})
+		d.defined = append(d.defined, false)
+		d.own = append(d.own, false)
+		d.used = append(d.used, false)
+	}
+}
+
+// selectColor makes register nc the one painted with.
+func (d *decoder) selectColor(nc int) {
+	d.top = max(d.top, nc)
+	d.reg = nc
+	if d.paletted {
+		d.pen = [4]byte{byte(nc + 1), byte((nc + 1) >> 8)}
+		return
+	}
+	c := d.colors[nc]
+	d.pen = [4]byte{c.R, c.G, c.B, c.A}
+}
+
+// paint draws the sixel bits n times at the active position and advances it.
+func (d *decoder) paint(bits byte, n int) error {
+	if d.x+n > d.limits.width || d.x+n < 0 {
+		return &LimitError{"width", d.x + n, d.limits.width}
+	}
+	if bits != 0 && (d.x+n > d.dw || d.y+6 > d.dh) {
+		if err := d.checkSize(d.x+n, d.y+sixelRows(bits)); err != nil {
+			return err
+		}
+	}
+	if bits != 0 && n > 0 {
+		d.used[d.reg] = true
+	}
+	if d.measure {
+		if bits != 0 {
+			d.dh = max(d.dh, d.y+sixelRows(bits))
+		}
+	} else if bits != 0 {
+		// row of the canvas the band starts at
+		y := d.y
+		if d.scale > 1 {
+			y = 0
+		}
+		d.grow(d.x+n, y+6)
+		if d.progress != nil {
+			r := image.Rect(d.x, d.y+sixelTop(bits), d.x+n, d.y+sixelRows(bits))
+			d.dirty = d.dirty.Union(r)
+		}
+		pen := d.pen
+		for p := 0; p < 6; p++ {
+			if bits&(1<<uint(p)) == 0 {
+				continue
+			}
+			o := (y+p)*d.stride + d.x*d.bpp
+			row := d.pix[o : o+n*d.bpp : o+n*d.bpp]
+			if d.bpp == 2 {
+				for i := 0; i < len(row); i += 2 {
+					row[i] = pen[0]
+					row[i+1] = pen[1]
+				}
+			} else {
+				for i := 0; i < len(row); i += 4 {
+					row[i] = pen[0]
+					row[i+1] = pen[1]
+					row[i+2] = pen[2]
+					row[i+3] = pen[3]
+				}
+			}
+			if d.dh < d.y+p+1 {
+				d.dh = d.y + p + 1
+			}
+		}
+	}
+	d.x += n
+	if d.dw < d.x {
+		d.dw = d.x
+	}
+	return nil
+}
+
+// checkSize reports a *LimitError if the image would exceed the limits
+// when extended to w x h pixels.
+func (d *decoder) checkSize(w, h int) error {
+	switch {
+	case w > d.limits.width:
+		return &LimitError{"width", w, d.limits.width}
+	case h > d.limits.height:
+		return &LimitError{"height", h, d.limits.height}
+	}
+	ow, oh := d.size()
+	ow, oh = max(ow, w), max(oh, h)
+	if int64(ow)*int64(oh) > int64(d.limits.pixels) {
+		return &LimitError{"pixels", ow * oh, d.limits.pixels}
+	}
+	return nil
+}
+
+// sixelRows returns the number of rows sixel ch reaches down to.
+func sixelRows(ch byte) int {
+	return bits.Len8(ch)
+}
+
+// sixelTop returns the first row painted by sixel ch.
+func sixelTop(ch byte) int {
+	return bits.TrailingZeros8(ch)
+}
+
+// report passes the area painted since the last call to the progress
+// callback.
+func (d *decoder) report() {
+	if d.dirty.Empty() {
+		return
+	}
+	w, h := d.size()
+	rect := image.Rect(0, 0, min(w, d.w), min(h, d.h))
+	var img image.Image
+	if d.paletted {
+		img = &registerImage{d: d, rect: rect}
+	} else {
+		img = &image.NRGBA{Pix: d.pix, Stride: d.stride, Rect: rect}
+	}
+	d.progress(img, d.dirty)
+	d.dirty = image.Rectangle{}
+}
+
+// registerImage shows the canvas of a paletted decoder with the current
+// colors of the registers.
+type registerImage struct {
+	d    *decoder
+	rect image.Rectangle
+}
+
+func (r *registerImage) ColorModel() color.Model { return color.NRGBAModel }
+
+func (r *registerImage) Bounds() image.Rectangle { return r.rect }
+
+func (r *registerImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(r.rect) {
+		return color.NRGBA{}
+	}
+	d := r.d
+	o := y*d.stride + x*2
+	v := int(d.pix[o]) | int(d.pix[o+1])<<8
+	switch {
+	case v == 0:
+		return color.NRGBA{}
+	case v-1 < len(d.colors) && d.defined[v-1]:
+		return d.colors[v-1]
+	default:
+		return color.NRGBA{0, 0, 0, 0xFF}
+	}
+}
+
+// grow makes the canvas at least w x h pixels, at least doubling it in each
+// direction it grows as far as the limits and the declared raster size
+// allow. The canvas only grows toward what is painted, so a large raster
+// size costs nothing until the image is output.
+func (d *decoder) grow(w, h int) {
+	if w <= d.w && (h <= d.h || d.scale > 1) {
+		return
+	}
+	mw, mh := d.limits.width, d.limits.height
+	if d.raster && d.ph > 0 && d.pv > 0 {
+		mw, mh = min(mw, d.ph), min(mh, d.pv)
+	}
+	nw, nh := d.w, d.h
+	if w > nw {
+		nw = min(max(w, 2*nw, 200), max(w, mw))
+	}
+	if h > nh {
+		nh = min(max(h, 2*nh, 200), max(h, mh))
+	}
+	if d.scale > 1 {
+		// the canvas holds just the current band
+		nh = 6
+	}
+	if nw*nh > d.limits.pixels {
+		// just enough for what has been painted
+		nw = max(w, d.dw)
+		if d.scale <= 1 {
+			nh = max(h, d.dh)
+		}
+	}
+	pix := make([]byte, nw*nh*d.bpp)
+	cw := min(d.w, nw) * d.bpp
+	for y := 0; y < min(d.h, nh); y++ {
+		copy(pix[y*nw*d.bpp:], d.pix[y*d.stride:y*d.stride+cw])
+	}
+	d.pix, d.stride, d.w, d.h = pix, nw*d.bpp, nw, nh
+}
+
+// header returns the parameters read so far.
+func (d *decoder) header() Header {
+	return Header{
+		P1:               d.p[0],
+		P2:               d.p[1],
+		P3:               d.p[2],
+		RasterAttributes: d.raster,
+		Pan:              d.pan,
+		Pad:              d.pad,
+		Ph:               d.ph,
+		Pv:               d.pv,
+	}
+}
+
+// info returns how the image was drawn; n is its length in bytes.
+func (d *decoder) info(n int64) Info {
+	info := Info{Width: d.dw, Height: d.dh, Bytes: n}
+	for i, c := range d.colors {
+		if d.own[i] || d.used[i] {
+			info.Registers = append(info.Registers, Register{N: i, Color: c, Defined: d.own[i], Used: d.used[i]})
+		}
+	}
+	return info
+}
+
+// size returns the size of the decoded image: the declared raster size if
+// there is one, extended to everything painted.
+func (d *decoder) size() (int, int) {
+	if d.raster {
+		return max(d.ph, d.dw), max(d.pv, d.dh)
+	}
+	return d.dw, d.dh
+}
+
+// maxAspect bounds both terms of the pixel aspect ratio from the raster
+// attributes, so that stretching cannot make an image much taller.
+const maxAspect = 10
+
+// aspect returns the pixel aspect ratio as vertical:horizontal, from the
+// raster attributes or else the DCS P1 parameter. Ratios from the raster
+// attributes are reduced and their terms clamped to maxAspect.
+func (d *decoder) aspect() (int, int) {
+	if !d.raster {
+		return pixelAspect(d.p[0]), 1
+	}
+	num, den := max(d.pan, 1), max(d.pad, 1)
+	a, b := num, den
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return min(num/a, maxAspect), min(den/a, maxAspect)
+}
+
+// fillRect returns the area whose unpainted pixels get the background
+// color: the raster area when P2 is 0 or 2, empty when it is 1.
+func (d *decoder) fillRect() image.Rectangle {
+	if d.p[1] == 1 || d.noFill {
+		return image.Rectangle{}
+	}
+	if d.raster {
+		return image.Rect(0, 0, d.ph, d.pv)
+	}
+	return image.Rect(0, 0, d.dw, d.dh)
+}
+
+// image returns the decoded image.
+func (d *decoder) image() image.Image {
+	if d.scale > 1 {
+		return d.scaledImage()
+	}
+	ow, oh := d.size()
+	rect := image.Rect(0, 0, ow, oh)
+	// canvas area inside the image
+	w, h := min(ow, d.w), min(oh, d.h)
+	fill := d.fillRect()
+	if !d.paletted {
+		var img *image.NRGBA
+		if w == ow && h == oh && ow > 0 && oh > 0 {
+			// the canvas covers the image and is not used anymore
+			img = &image.NRGBA{Pix: d.pix[:(oh-1)*d.stride+ow*4], Stride: d.stride, Rect: rect}
+		} else {
+			img = image.NewNRGBA(rect)
+			for y := 0; y < h; y++ {
+				copy(img.Pix[y*img.Stride:y*img.Stride+w*4], d.pix[y*d.stride:])
+			}
+		}
+		if !fill.Empty() {
+			c := d.background()
+			for y := fill.Min.Y; y < fill.Max.Y; y++ {
+				row := img.Pix[y*img.Stride : y*img.Stride+fill.Dx()*4]
+				for i := 0; i < len(row); i += 4 {
+					// painted pixels are always opaque
+					if row[i+3] == 0 {
+						row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+					}
+				}
+			}
+		}
+		return img
+	}
+
+	palette := make(color.Palette, d.top+1, d.top+3)
+	for i := range palette {
+		if i < len(d.colors) && d.defined[i] {
+			palette[i] = d.colors[i]
+		} else {
+			palette[i] = color.NRGBA{0, 0, 0, 0xFF}
+		}
+	}
+	// look for unpainted pixels inside and outside the fill area
+	filled, unpainted := false, false
+	for y := 0; y < oh && !(filled && unpainted); y++ {
+		for x := 0; x < ow; x++ {
+			if x < w && y < h {
+				o := y*d.stride + x*2
+				if d.pix[o] != 0 || d.pix[o+1] != 0 {
+					continue
+				}
+			}
+			if image.Pt(x, y).In(fill) {
+				filled = true
+			} else {
+				unpainted = true
+			}
+		}
+	}
+	// background is the palette index of the background color, register 0
+	// unless one was configured
+	background := 0
+	if filled && d.bg != nil {
+		background = len(palette)
+		palette = append(palette, d.background())
+	}
+	transparent := len(palette)
+	if unpainted {
+		palette = append(palette, color.NRGBA{})
+	}
+	if len(palette) > 256 {
+		img := image.NewNRGBA(rect)
+		for y := 0; y < oh; y++ {
+			dst := img.Pix[y*img.Stride : (y+1)*img.Stride]
+			for x := 0; x < ow; x++ {
+				i := d.index(x, y, w, h, fill, background, transparent)
+				if i < len(palette) {
+					c := palette[i].(color.NRGBA)
+					dst[x*4], dst[x*4+1], dst[x*4+2], dst[x*4+3] = c.R, c.G, c.B, c.A
+				}
+			}
+		}
+		return img
+	}
+	img := image.NewPaletted(rect, palette)
+	for y := 0; y < oh; y++ {
+		dst := img.Pix[y*img.Stride : (y+1)*img.Stride]
+		for x := range dst {
+			dst[x] = uint8(d.index(x, y, w, h, fill, background, transparent))
+		}
+	}
+	return img
+}
+
+// index returns the palette index of the pixel at (x, y) in paletted mode.
+// w and h are the part of the canvas inside the image.
+func (d *decoder) index(x, y, w, h int, fill image.Rectangle, background, transparent int) int {
+	if x < w && y < h {
+		o := y*d.stride + x*2
+		if v := int(d.pix[o]) | int(d.pix[o+1])<<8; v > 0 {
+			return v - 1
+		}
+	}
+	if image.Pt(x, y).In(fill) {
+		return background
+	}
+	return transparent
+}
+
+// background returns the color unpainted pixels are filled with.
+func (d *decoder) background() color.NRGBA {
+	if d.bg != nil {
+		return color.NRGBAModel.Convert(d.bg).(color.NRGBA)
+	}
+	return d.colors[0]
+}
+
+// stretch scales img vertically by num/den, duplicating or dropping rows.
+// img is an *image.NRGBA or *image.Paletted from decoder.image.
+func stretch(img image.Image, num, den int) image.Image {
+	if num == den {
+		return img
+	}
+	b := img.Bounds()
+	h := (b.Dy()*num + den/2) / den
+	var src, dst []byte
+	var sstride, dstride int
+	var out image.Image
+	switch p := img.(type) {
+	case *image.NRGBA:
+		o := image.NewNRGBA(image.Rect(0, 0, b.Dx(), h))
+		src, dst, sstride, dstride, out = p.Pix, o.Pix, p.Stride, o.Stride, o
+	case *image.Paletted:
+		o := image.NewPaletted(image.Rect(0, 0, b.Dx(), h), p.Palette)
+		src, dst, sstride, dstride, out = p.Pix, o.Pix, p.Stride, o.Stride, o
+	default:
+		return img
+	}
+	for y := 0; y < h; y++ {
+		sy := y * den / num
+		copy(dst[y*dstride:(y+1)*dstride], src[sy*sstride:])
+	}
+	return out
+}
+
+func sixelRGB(r, g, b uint) color.NRGBA {
+	r, g, b = min(r, 100), min(g, 100), min(b, 100)
+	return color.NRGBA{uint8((r*0xFF + 50) / 100), uint8((g*0xFF + 50) / 100), uint8((b*0xFF + 50) / 100), 0xFF}
+}
+
+func sixelHLS(h, l, s uint) color.NRGBA {
+	l, s = min(l, 100), min(s, 100)
+	var r, g, b, max, min float64
+
+	/* https://wikimedia.org/api/rest_v1/media/math/render/svg/17e876f7e3260ea7fed73f69e19c71eb715dd09d */
+	/* https://wikimedia.org/api/rest_v1/media/math/render/svg/f6721b57985ad83db3d5b800dc38c9980eedde1d */
+	if l > 50 {
+		max = float64(l) + float64(s)*(1.0-float64(l)/100.0)
+		min = float64(l) - float64(s)*(1.0-float64(l)/100.0)
+	} else {
+		max = float64(l) + float64(s*l)/100.0
+		min = float64(l) - float64(s*l)/100.0
+	}
+
+	/* sixel hue color ring is roteted -120 degree from nowdays general one. */
+	h = (h + 240) % 360
+
+	/* https://wikimedia.org/api/rest_v1/media/math/render/svg/937e8abdab308a22ff99de24d645ec9e70f1e384 */
+	switch h / 60 {
+	case 0: /* 0 <= hue < 60 */
+		r = max
+		g = min + (max-min)*(float64(h)/60.0)
+		b = min
+	case 1: /* 60 <= hue < 120 */
+		r = min + (max-min)*(float64(120-h)/60.0)
+		g = max
+		b = min
+	case 2: /* 120 <= hue < 180 */
+		r = min
+		g = max
+		b = min + (max-min)*(float64(h-120)/60.0)
+	case 3: /* 180 <= hue < 240 */
+		r = min
+		g = min + (max-min)*(float64(240-h)/60.0)
+		b = max
+	case 4: /* 240 <= hue < 300 */
+		r = min + (max-min)*(float64(h-240)/60.0)
+		g = min
+		b = max
+	case 5: /* 300 <= hue < 360 */
+		r = max
+		g = min
+		b = min + (max-min)*(float64(360-h)/60.0)
+	default:
+	}
+	return sixelRGB(uint(r), uint(g), uint(b))
+}
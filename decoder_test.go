@@ -0,0 +1,617 @@
+package sixel
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeString(t *testing.T, s string) image.Image {
+	t.Helper()
+	var img image.Image
+	if err := NewDecoder(strings.NewReader(s)).Decode(&img); err != nil {
+		t.Fatalf("Decode(%q) returned error: %v", s, err)
+	}
+	return img
+}
+
+func TestDecodeTokens(t *testing.T) {
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	blue := color.NRGBA{0, 0, 0xFF, 0xFF}
+	for _, tt := range []struct {
+		name  string
+		input string
+		w, h  int
+		at    map[image.Point]color.NRGBA
+	}{
+		{
+			name:  "repeat and carriage return",
+			input: "\x1bPq#1;2;100;0;0!3~$#2;2;0;0;100A\x1b\\",
+			w:     3, h: 6,
+			at: map[image.Point]color.NRGBA{{0, 0}: red, {0, 1}: blue, {2, 5}: red},
+		},
+		{
+			name:  "repeat count of zero",
+			input: "\x1bPq#1;2;100;0;0!0~#2;2;0;0;100~\x1b\\",
+			w:     2, h: 6,
+			at: map[image.Point]color.NRGBA{{0, 0}: red, {1, 0}: blue},
+		},
+		{
+			name:  "next line",
+			input: "\x1bP0;1q#1;2;100;0;0@-@\x1b\\",
+			w:     1, h: 7,
+			at: map[image.Point]color.NRGBA{{0, 0}: red, {0, 6}: red, {0, 3}: {}},
+		},
+		{
+			name:  "8-bit controls and ignored C0",
+			input: "\x90q#1;2;100;0;0\r\n~~\x9c",
+			w:     2, h: 6,
+			at: map[image.Point]color.NRGBA{{1, 5}: red},
+		},
+		{
+			name:  "HLS",
+			input: "\x1bP0;1;0q#1;1;120;50;100@\x1b\\",
+			w:     1, h: 1,
+			at: map[image.Point]color.NRGBA{{0, 0}: red},
+		},
+		{
+			name:  "predefined register",
+			input: "\x1bPq#2@\x1b\\",
+			w:     1, h: 1,
+			at: map[image.Point]color.NRGBA{{0, 0}: sixelRGB(80, 13, 13)},
+		},
+		{
+			name:  "missing ST",
+			input: "text\x1bPq#1;2;100;0;0~",
+			w:     1, h: 6,
+			at: map[image.Point]color.NRGBA{{0, 5}: red},
+		},
+	} {
+		img := decodeString(t, tt.input)
+		if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("%s: got size %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		for p, want := range tt.at {
+			if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != want {
+				t.Errorf("%s: pixel %v: got %v, want %v", tt.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, input := range []string{
+		"\x1bXq~\x1b\\",
+		"\x1bPq#300~\x1b\\",
+		"\x1bPq#1;2;100~\x1b\\",
+		"\x1bPq!5#\x1b\\",
+		"\x1bPq~ ~\x1b\\",
+	} {
+		var img image.Image
+		if err := NewDecoder(strings.NewReader(input)).Decode(&img); err == nil {
+			t.Errorf("Decode(%q) succeeded", input)
+		}
+	}
+}
+
+func TestDecodePaletted(t *testing.T) {
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.NRGBA{uint8(i), uint8(255 - i), uint8(i / 2), 255}
+	}
+	src := image.NewPaletted(image.Rect(0, 0, 256, 6), palette)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	var first, second bytes.Buffer
+	if err := NewEncoder(&first).Encode(src); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	dec := NewDecoder(bytes.NewReader(first.Bytes()))
+	dec.Paletted = true
+	var img image.Image
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Decode returned %T, want *image.Paletted", img)
+	}
+	if !bytes.Equal(p.Pix, src.Pix) {
+		t.Fatalf("register indices were not preserved")
+	}
+	if err := NewEncoder(&second).Encode(p); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatalf("decode/encode round trip is not lossless")
+	}
+}
+
+func TestDecodePalettedTransparent(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("\x1bP0;1q#20;2;100;0;0A\x1b\\"))
+	dec.Paletted = true
+	var img image.Image
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	p := img.(*image.Paletted)
+	if len(p.Palette) != 22 {
+		t.Fatalf("got %d palette entries, want registers 0-20 and a transparent one", len(p.Palette))
+	}
+	if p.Pix[0] != 21 || p.Pix[1] != 20 {
+		t.Fatalf("got indices %v, want [21 20]", p.Pix)
+	}
+	if _, _, _, a := p.Palette[21].RGBA(); a != 0 {
+		t.Fatalf("unpainted entry is not transparent")
+	}
+}
+
+func TestDecodePalettedTooManyRegisters(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("\x1bP0;1q#300;2;100;0;0A\x1b\\"))
+	dec.Paletted = true
+	var img image.Image
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if _, ok := img.(*image.NRGBA); !ok {
+		t.Fatalf("Decode returned %T, want *image.NRGBA", img)
+	}
+	if got := color.NRGBAModel.Convert(img.At(0, 1)); got != (color.NRGBA{0xFF, 0, 0, 0xFF}) {
+		t.Fatalf("got %v, want red", got)
+	}
+}
+
+func TestDecodeRasterSize(t *testing.T) {
+	// the declared size is kept even where nothing is painted
+	img := decodeString(t, "\x1bPq\"1;1;8;12#1;2;100;0;0~~\x1b\\")
+	if got := img.Bounds(); got != image.Rect(0, 0, 8, 12) {
+		t.Fatalf("got bounds %v, want 8x12", got)
+	}
+	// painting beyond it extends the image
+	img = decodeString(t, "\x1bPq\"1;1;2;2#1;2;100;0;0~~~\x1b\\")
+	if got := img.Bounds(); got != image.Rect(0, 0, 3, 6) {
+		t.Fatalf("got bounds %v, want 3x6", got)
+	}
+}
+
+func TestDecodeRasterSizeAllocation(t *testing.T) {
+	// a declared size allocates the image once, not a canvas before it
+	const w, h = 3000, 3000
+	for _, input := range []string{
+		"\x1bPq\"1;1;3000;3000#0~\x1b\\",
+		"\x1bPq\"1;1;3000;3000#1;2;100;0;0!3000~\x1b\\",
+	} {
+		n := allocated(func() { decodeString(t, input) })
+		if max := uint64(w*h*4) * 5 / 4; n > max {
+			t.Fatalf("Decode(%.24q) allocated %d bytes, want at most %d", input, n, max)
+		}
+	}
+}
+
+func TestDecodeAspectRatio(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		h     int
+	}{
+		{"\x1bPq\"2;1;1;6#1;2;100;0;0~\x1b\\", 12},
+		{"\x1bP0q#1;2;100;0;0~\x1b\\", 12},
+		{"\x1bP2q#1;2;100;0;0~\x1b\\", 30},
+		{"\x1bP9q#1;2;100;0;0~\x1b\\", 6},
+		// raster attributes override P1
+		{"\x1bP2q\"1;1#1;2;100;0;0~\x1b\\", 6},
+		// ratios are reduced, and their terms clamped to 10
+		{"\x1bPq\"200;100#1;2;100;0;0~\x1b\\", 12},
+		{"\x1bPq\"100000;1#1;2;100;0;0~\x1b\\", 60},
+	} {
+		for _, paletted := range []bool{false, true} {
+			dec := NewDecoder(strings.NewReader(tt.input))
+			dec.AspectRatio = true
+			dec.Paletted = paletted
+			var img image.Image
+			if err := dec.Decode(&img); err != nil {
+				t.Fatalf("Decode(%q) returned error: %v", tt.input, err)
+			}
+			if got := img.Bounds().Dy(); got != tt.h {
+				t.Fatalf("Decode(%q) height = %d, want %d", tt.input, got, tt.h)
+			}
+			if got := color.NRGBAModel.Convert(img.At(0, tt.h-1)); got != (color.NRGBA{0xFF, 0, 0, 0xFF}) {
+				t.Fatalf("Decode(%q) bottom pixel = %v, want red", tt.input, got)
+			}
+		}
+	}
+	// the stretched image must keep within the limits
+	dec := NewDecoder(strings.NewReader("\x1bPq\"100000;1;100;100#0~\x1b\\"))
+	dec.AspectRatio = true
+	dec.MaxHeight = 512
+	var img image.Image
+	var le *LimitError
+	if err := dec.Decode(&img); !errors.As(err, &le) || le.Limit != "height" {
+		t.Fatalf("Decode returned %v, want a height *LimitError", err)
+	}
+	// without AspectRatio each sixel pixel is one image pixel
+	if h := decodeString(t, "\x1bP0q#1;2;100;0;0~\x1b\\").Bounds().Dy(); h != 6 {
+		t.Fatalf("got height %d, want 6", h)
+	}
+}
+
+func TestDecodeBackground(t *testing.T) {
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	green := color.NRGBA{0, 0xFF, 0, 0xFF}
+	reg0 := color.NRGBA{0, 0xFF, 0xFF, 0xFF}
+	for _, tt := range []struct {
+		name     string
+		input    string
+		bg       color.Color
+		in, out  color.NRGBA // unpainted pixels inside and outside the raster area
+		w, h, p2 int
+	}{
+		{
+			name:  "register 0",
+			input: "\x1bP0;0q\"1;1;2;6#0;2;0;100;100#1;2;100;0;0@!3?@\x1b\\",
+			in:    reg0, out: color.NRGBA{},
+			w: 5, h: 6, p2: 0,
+		},
+		{
+			name:  "configured",
+			input: "\x1bP0;2q\"1;1;2;6#1;2;100;0;0@!3?@\x1b\\",
+			bg:    green,
+			in:    green, out: color.NRGBA{},
+			w: 5, h: 6, p2: 2,
+		},
+		{
+			name:  "transparent",
+			input: "\x1bP0;1q\"1;1;2;6#1;2;100;0;0@!3?@\x1b\\",
+			bg:    green,
+			in:    color.NRGBA{}, out: color.NRGBA{},
+			w: 5, h: 6, p2: 1,
+		},
+	} {
+		for _, paletted := range []bool{false, true} {
+			dec := NewDecoder(strings.NewReader(tt.input))
+			dec.Background = tt.bg
+			dec.Paletted = paletted
+			var img image.Image
+			if err := dec.Decode(&img); err != nil {
+				t.Fatalf("%s: Decode returned error: %v", tt.name, err)
+			}
+			if got := dec.Header().P2; got != tt.p2 {
+				t.Fatalf("%s: got P2 %d, want %d", tt.name, got, tt.p2)
+			}
+			if got := img.Bounds(); got != image.Rect(0, 0, tt.w, tt.h) {
+				t.Fatalf("%s: got bounds %v", tt.name, got)
+			}
+			for p, want := range map[image.Point]color.NRGBA{
+				{0, 0}: red, {4, 0}: red, {1, 0}: tt.in, {1, 1}: tt.in, {2, 1}: tt.out,
+			} {
+				if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != want {
+					t.Errorf("%s (paletted %v): pixel %v: got %v, want %v", tt.name, paletted, p, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("\x1bP7;1;3q\"2;1;10;20#1;2;100;0;0~\x1b\\"))
+	var img image.Image
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	want := Header{P1: 7, P2: 1, P3: 3, RasterAttributes: true, Pan: 2, Pad: 1, Ph: 10, Pv: 20}
+	if got := dec.Header(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeInfo(t *testing.T) {
+	input := "\x1bPq\"1;1;10;20#1;2;100;0;0#2;2;0;100;0#1@@#3!3N#2\x1b\\"
+	dec := NewDecoder(strings.NewReader("text" + input + "more"))
+	if _, err := dec.Next(); err != nil {
+		t.Fatalf("Next returned error: %v", err)
+	}
+	info := dec.Info()
+	want := Info{
+		Width:  5,
+		Height: 4,
+		Registers: []Register{
+			{N: 1, Color: color.NRGBA{0xFF, 0, 0, 0xFF}, Defined: true, Used: true},
+			{N: 2, Color: color.NRGBA{0, 0xFF, 0, 0xFF}, Defined: true},
+			{N: 3, Color: vt340Colors[3], Used: true},
+		},
+		Bytes: int64(len(input)),
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestImageDecode(t *testing.T) {
+	for _, input := range []string{
+		"\x1bP0;0;8q\"1;1;4;12#1;2;100;0;0~~\x1b\\",
+		"\x90q\"1;1;4;12#1;2;100;0;0~~\x9c",
+	} {
+		img, name, err := image.Decode(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("image.Decode(%q) returned error: %v", input, err)
+		}
+		if name != "sixel" {
+			t.Fatalf("image.Decode(%q) returned format %q", input, name)
+		}
+		if got := img.Bounds(); got != image.Rect(0, 0, 4, 12) {
+			t.Fatalf("image.Decode(%q) returned bounds %v", input, got)
+		}
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		w, h  int
+	}{
+		{"\x1bPq\"1;1;640;480#1;2;100;0;0~~\x1b\\", 640, 480},
+		// without raster attributes the extent is scanned
+		{"\x1bPq#1;2;100;0;0~~$!5@-N\x1b\\", 5, 10},
+		{"\x1bPq\"1;1#1;2;100;0;0~~-?\x1b\\", 2, 6},
+	} {
+		cfg, name, err := image.DecodeConfig(strings.NewReader(tt.input))
+		if err != nil {
+			t.Fatalf("image.DecodeConfig(%q) returned error: %v", tt.input, err)
+		}
+		if name != "sixel" || cfg.Width != tt.w || cfg.Height != tt.h {
+			t.Fatalf("image.DecodeConfig(%q) = %s %dx%d, want sixel %dx%d", tt.input, name, cfg.Width, cfg.Height, tt.w, tt.h)
+		}
+	}
+	if _, err := DecodeConfig(strings.NewReader("")); err == nil {
+		t.Fatalf("DecodeConfig of empty input succeeded")
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		limit string
+	}{
+		{"\x1bPq!999999999~\x1b\\", "width"},
+		{"\x1bPq!999999999?\x1b\\", "width"},
+		{"\x1bPq\"1;1;100;100000~\x1b\\", "height"},
+		{"\x1bPq" + strings.Repeat("-", 3000) + "~\x1b\\", "height"},
+		{"\x1bPq\"1;1;16000;16000~\x1b\\", "pixels"},
+		{"\x1bPq!16000~" + strings.Repeat("-", 2000) + "~\x1b\\", "pixels"},
+		{"\x1bPq#5000;2;0;0;0~\x1b\\", "color registers"},
+	} {
+		var img image.Image
+		err := NewDecoder(strings.NewReader(tt.input)).Decode(&img)
+		le, ok := err.(*LimitError)
+		if !ok {
+			t.Errorf("Decode(%.40q) returned %v, want a *LimitError", tt.input, err)
+			continue
+		}
+		if le.Limit != tt.limit {
+			t.Errorf("Decode(%.40q) exceeded %s limit, want %s", tt.input, le.Limit, tt.limit)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader("\x1bPq#1;2;100;0;0!20~\x1b\\"))
+	dec.MaxWidth = 10
+	var img image.Image
+	if err := dec.Decode(&img); err == nil {
+		t.Fatalf("Decode succeeded beyond MaxWidth")
+	}
+	dec = NewDecoder(strings.NewReader("\x1bPq#20000;2;100;0;0!20~\x1b\\"))
+	dec.MaxColorRegisters = 1 << 16
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, s := range []string{
+		"\x1bPq#1;2;100;0;0!3~$#2;2;0;0;100A\x1b\\",
+		"\x1bP0;1;8q\"1;1;10;12#0;2;0;0;0#1;1;120;50;100~-~\x1b\\",
+		"\x90q#1;2;100;0;0@-@\x9c",
+		"\x1bP7;2q\"2;1#300;2;1;2;3!10N\x1b\\",
+		"\x1bPq\"100000;1;100;100#0~\x1b\\",
+		"\x1bP2q#1;2;100;0;0~~-~#5~\x1b\\",
+	} {
+		for i := 0; i < 8; i++ {
+			f.Add([]byte(s), i&1 != 0, i&2 != 0, i&4 != 0)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, paletted, aspect, lenient bool) {
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.Paletted = paletted
+		dec.AspectRatio = aspect
+		dec.Lenient = lenient
+		dec.MaxWidth = 512
+		dec.MaxHeight = 512
+		dec.MaxPixels = 1 << 16
+		dec.MaxColorRegisters = 1024
+		var img image.Image
+		// lenient decoding returns what was painted along with errors
+		if dec.Decode(&img); img == nil {
+			return
+		}
+		b := img.Bounds()
+		if b.Dx() > 512 || b.Dy() > 512 || b.Dx()*b.Dy() > 1<<16 {
+			t.Fatalf("Decode returned a %dx%d image beyond the limits", b.Dx(), b.Dy())
+		}
+	})
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	f.Add([]byte("\x1bPq\"1;1;640;480#1;2;100;0;0~~\x1b\\"))
+	f.Add([]byte("\x1bPq#1;2;100;0;0~~$!5@-N\x1b\\"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if cfg.Width > DefaultMaxWidth || cfg.Height > DefaultMaxHeight {
+			t.Fatalf("DecodeConfig returned %dx%d beyond the limits", cfg.Width, cfg.Height)
+		}
+	})
+}
+
+func TestDecodeSyntaxError(t *testing.T) {
+	for _, tt := range []struct {
+		input        string
+		err          error
+		offset       int64
+		band, column int
+	}{
+		{"\x1bXq~\x1b\\", ErrHeader, 1, 0, 0},
+		{"\x1bPq~~-~ ~\x1b\\", ErrToken, 7, 1, 1},
+		{"\x1bPq~!5#\x1b\\", ErrRepeat, 4, 0, 1},
+		{"\x1bPq#1;2;100~\x1b\\", ErrColor, 3, 0, 0},
+		{"\x1bPq~~#300~\x1b\\", ErrUndefinedColor, 5, 0, 2},
+		{"\x1bPq~\x1b[0m\x1b\\", ErrToken, 4, 0, 1},
+	} {
+		var img image.Image
+		err := NewDecoder(strings.NewReader(tt.input)).Decode(&img)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Decode(%q) returned %v, want a *SyntaxError", tt.input, err)
+			continue
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Decode(%q) returned %v, want %v", tt.input, err, tt.err)
+		}
+		if se.Offset != tt.offset || se.Band != tt.band || se.Column != tt.column {
+			t.Errorf("Decode(%q) returned error at offset %d, band %d, column %d; want %d, %d, %d",
+				tt.input, se.Offset, se.Band, se.Column, tt.offset, tt.band, tt.column)
+		}
+	}
+}
+
+func TestDecodeLenient(t *testing.T) {
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	black := color.NRGBA{0, 0, 0, 0xFF}
+	for _, tt := range []struct {
+		name  string
+		input string
+		w, h  int
+		at    map[image.Point]color.NRGBA
+	}{
+		{
+			name:  "unknown tokens and stray controls",
+			input: "\x1bXjunk\x1bP0;1q#1;2;100;0;0~ ~\x07!2*~\x1b\\",
+			w:     3, h: 6,
+			at: map[image.Point]color.NRGBA{{0, 0}: red, {2, 5}: red},
+		},
+		{
+			name:  "undefined register and short color",
+			input: "\x1bP0;1q#300~#1;2;100~\x1b\\",
+			w:     2, h: 6,
+			at: map[image.Point]color.NRGBA{{0, 0}: black, {1, 0}: {0xFF, 0, 0, 0xFF}},
+		},
+		{
+			name:  "escape sequence ends the image",
+			input: "\x1bP0;1q#1;2;100;0;0~\x1b[0m~",
+			w:     1, h: 6,
+			at: map[image.Point]color.NRGBA{{0, 0}: red},
+		},
+	} {
+		dec := NewDecoder(strings.NewReader(tt.input))
+		dec.Lenient = true
+		var img image.Image
+		if err := dec.Decode(&img); err != nil {
+			t.Fatalf("%s: Decode returned error: %v", tt.name, err)
+		}
+		if got := img.Bounds(); got != image.Rect(0, 0, tt.w, tt.h) {
+			t.Fatalf("%s: got bounds %v, want %dx%d", tt.name, got, tt.w, tt.h)
+		}
+		for p, want := range tt.at {
+			if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != want {
+				t.Errorf("%s: pixel %v: got %v, want %v", tt.name, p, got, want)
+			}
+		}
+	}
+
+	// errors that cannot be skipped come with the partial image
+	dec := NewDecoder(strings.NewReader("\x1bPq#1;2;100;0;0~~-~#9999~\x1b\\"))
+	dec.Lenient = true
+	var img image.Image
+	err := dec.Decode(&img)
+	if _, ok := err.(*LimitError); !ok {
+		t.Fatalf("Decode returned %v, want a *LimitError", err)
+	}
+	if img == nil || img.Bounds() != image.Rect(0, 0, 2, 12) {
+		t.Fatalf("Decode returned partial image %v, want 2x12", img)
+	}
+}
+
+func TestDecodeProgress(t *testing.T) {
+	input := "\x1bP0;1q#1;2;100;0;0!3~-$!2?@#2;2;0;0;100@-\x1b\\"
+	for _, paletted := range []bool{false, true} {
+		var dirty []image.Rectangle
+		var colors []color.NRGBA
+		dec := NewDecoder(strings.NewReader(input))
+		dec.Paletted = paletted
+		dec.Progress = func(img image.Image, r image.Rectangle) {
+			if !r.In(img.Bounds()) {
+				t.Errorf("dirty rectangle %v outside of %v", r, img.Bounds())
+			}
+			dirty = append(dirty, r)
+			colors = append(colors, color.NRGBAModel.Convert(img.At(r.Min.X, r.Min.Y)).(color.NRGBA))
+		}
+		var img image.Image
+		if err := dec.Decode(&img); err != nil {
+			t.Fatalf("Decode returned error: %v", err)
+		}
+		want := []image.Rectangle{image.Rect(0, 0, 3, 6), image.Rect(2, 6, 4, 7)}
+		if len(dirty) != len(want) {
+			t.Fatalf("paletted %v: got dirty rectangles %v, want %v", paletted, dirty, want)
+		}
+		for i := range want {
+			if dirty[i] != want[i] {
+				t.Errorf("paletted %v: got dirty rectangles %v, want %v", paletted, dirty, want)
+			}
+		}
+		if colors[0] != (color.NRGBA{0xFF, 0, 0, 0xFF}) || colors[1] != (color.NRGBA{0xFF, 0, 0, 0xFF}) {
+			t.Errorf("paletted %v: got colors %v", paletted, colors)
+		}
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	gray := color.RGBA{0x80, 0x80, 0x80, 0xFF}
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{gray}, image.Point{}, draw.Src)
+
+	// P2=1 leaves unpainted pixels alone, P2=0 fills its raster area with
+	// register 0
+	input := "\x1bP0;1q#1;2;100;0;0@?@\x1b\\" + "\x1bP0;0q\"1;1;2;6#0;2;0;0;100#1;2;0;100;0?@\x1b\\"
+	dec := NewDecoder(strings.NewReader(input))
+	if err := dec.DecodeInto(dst, image.Pt(1, 1)); err != nil {
+		t.Fatalf("DecodeInto returned error: %v", err)
+	}
+	if err := dec.DecodeInto(dst, image.Pt(5, 2)); err != nil {
+		t.Fatalf("DecodeInto returned error: %v", err)
+	}
+	if err := dec.DecodeInto(dst, image.Pt(0, 0)); err != io.EOF {
+		t.Fatalf("DecodeInto returned %v, want io.EOF", err)
+	}
+	for p, want := range map[image.Point]color.RGBA{
+		{0, 0}: gray,
+		{1, 1}: {0xFF, 0, 0, 0xFF},
+		{2, 1}: gray,
+		{3, 1}: {0xFF, 0, 0, 0xFF},
+		{1, 2}: gray,
+		{5, 2}: {0, 0, 0xFF, 0xFF},
+		{6, 2}: {0, 0xFF, 0, 0xFF},
+		{5, 7}: {0, 0, 0xFF, 0xFF},
+		{7, 2}: gray,
+	} {
+		if got := dst.RGBAAt(p.X, p.Y); got != want {
+			t.Errorf("pixel %v: got %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package detect asks a terminal what it supports, so that programs can
+// tell whether sixel images will be shown before printing them.
+//
+// The terminal is queried for its Primary Device Attributes (DA1, CSI c),
+// whose reply lists attribute 4 if it supports sixel graphics, its
+// background color (OSC 11), and its number of color registers and maximum
+// sixel geometry (XTSMGRAPHICS). Every terminal answers DA1, so the reply is
+// read up to it instead of waiting for the full timeout when a terminal
+// ignores the other queries.
+package detect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-sixel"
+)
+
+// DefaultTimeout is how long Detect waits for the terminal to reply.
+const DefaultTimeout = time.Second
+
+// ErrNoReply is returned when the terminal does not answer before the
+// timeout, as when it is not a terminal emulator at all.
+var ErrNoReply = errors.New("detect: no reply from terminal")
+
+// Capabilities holds what a terminal reported about itself.
+type Capabilities struct {
+	// Class is the first parameter of the DA1 reply, the conformance
+	// level of the terminal, such as 62 for a VT220 or 64 for a VT420.
+	Class int
+
+	// Attributes holds the other parameters of the DA1 reply.
+	Attributes []int
+
+	// Sixel reports whether the terminal supports sixel graphics.
+	Sixel bool
+
+	// Background is the background color of the terminal, or nil if it
+	// did not report it.
+	Background color.Color
+
+	// ColorRegisters is the number of color registers, and MaxWidth and
+	// MaxHeight the largest sixel image, reported with XTSMGRAPHICS. They
+	// are 0 if the terminal did not report them.
+	ColorRegisters int
+	MaxWidth       int
+	MaxHeight      int
+}
+
+// Profile returns a profile limiting the Encoder to the color registers and
+// geometry reported by the terminal, or nil if it reported neither. Only
+// Colors, MaxWidth and MaxHeight are set.
+func (c *Capabilities) Profile() *sixel.Profile {
+	if c.ColorRegisters == 0 && c.MaxWidth == 0 && c.MaxHeight == 0 {
+		return nil
+	}
+	return &sixel.Profile{
+		Name:      "detected",
+		Colors:    c.ColorRegisters,
+		MaxWidth:  c.MaxWidth,
+		MaxHeight: c.MaxHeight,
+	}
+}
+
+// The queries sent by Query: the background color, the number of color
+// registers and the maximum sixel geometry (XTSMGRAPHICS), then DA1.
+const (
+	queryBackground = "\x1b]11;?\x1b\\"
+	queryColors     = "\x1b[?1;1S"
+	queryGeometry   = "\x1b[?2;1S"
+	queryDA1        = "\x1b[c"
+)
+
+// XTSMGRAPHICS items.
+const (
+	itemColors   = 1
+	itemGeometry = 2
+)
+
+// parse reads the replies in b into c. It reports whether the DA1 reply
+// was found.
+func (c *Capabilities) parse(b []byte) bool {
+	if bg, ok := parseBackground(b); ok {
+		c.Background = bg
+	}
+	if v, err := graphicsReply(b, itemColors); err == nil && len(v) >= 1 {
+		c.ColorRegisters = v[0]
+	}
+	if v, err := graphicsReply(b, itemGeometry); err == nil && len(v) >= 2 {
+		c.MaxWidth, c.MaxHeight = v[0], v[1]
+	}
+	params, ok := parseDA1(b)
+	if !ok || len(params) == 0 {
+		return false
+	}
+	c.Class, c.Attributes = params[0], params[1:]
+	for _, a := range c.Attributes {
+		if a == 4 {
+			c.Sixel = true
+		}
+	}
+	return true
+}
+
+// csiReplies returns the parameters of the replies CSI ? params final in b,
+// 7-bit or 8-bit.
+func csiReplies(b []byte, final byte) [][]int {
+	var replies [][]int
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "\x1b\x9b")
+		if i < 0 {
+			break
+		}
+		b = b[i:]
+		var rest []byte
+		switch {
+		case bytes.HasPrefix(b, []byte("\x1b[?")):
+			rest = b[3:]
+		case bytes.HasPrefix(b, []byte("\x9b?")):
+			rest = b[2:]
+		default:
+			b = b[1:]
+			continue
+		}
+		end := bytes.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != ';'
+		})
+		if end < 0 {
+			// incomplete reply
+			break
+		}
+		b = rest[end:]
+		if rest[end] != final {
+			continue
+		}
+		var params []int
+		for _, p := range bytes.Split(rest[:end], []byte(";")) {
+			n, _ := strconv.Atoi(string(p))
+			params = append(params, n)
+		}
+		replies = append(replies, params)
+	}
+	return replies
+}
+
+// parseDA1 returns the parameters of the DA1 reply in b.
+func parseDA1(b []byte) ([]int, bool) {
+	replies := csiReplies(b, 'c')
+	if len(replies) == 0 {
+		return nil, false
+	}
+	return replies[0], true
+}
+
+// GraphicsError is returned when the terminal fails an XTSMGRAPHICS
+// request.
+type GraphicsError struct {
+	// Item is 1 for color registers and 2 for the sixel geometry.
+	Item int
+	// Status is the status of the reply: 1 for an invalid item, 2 for an
+	// invalid action and 3 for a failure.
+	Status int
+}
+
+func (e *GraphicsError) Error() string {
+	return fmt.Sprintf("detect: XTSMGRAPHICS item %d failed with status %d", e.Item, e.Status)
+}
+
+// ErrNoGraphics is returned when the terminal does not answer XTSMGRAPHICS.
+var ErrNoGraphics = errors.New("detect: terminal does not support XTSMGRAPHICS")
+
+// graphicsReply returns the values of the last XTSMGRAPHICS reply for item
+// in b, CSI ? item ; status ; values S.
+func graphicsReply(b []byte, item int) ([]int, error) {
+	var reply []int
+	for _, r := range csiReplies(b, 'S') {
+		if len(r) >= 2 && r[0] == item {
+			reply = r
+		}
+	}
+	switch {
+	case reply == nil:
+		return nil, ErrNoGraphics
+	case reply[1] != 0:
+		return nil, &GraphicsError{item, reply[1]}
+	}
+	return reply[2:], nil
+}
+
+// parseBackground returns the color of the OSC 11 reply in b, such as
+// ESC ] 11 ; rgb:ffff/ffff/dddd ST.
+func parseBackground(b []byte) (color.Color, bool) {
+	i := bytes.Index(b, []byte("]11;rgb:"))
+	if i < 0 {
+		return nil, false
+	}
+	b = b[i+len("]11;rgb:"):]
+	end := bytes.IndexAny(b, "\x07\x1b\x9c")
+	if end < 0 {
+		return nil, false
+	}
+	parts := bytes.Split(b[:end], []byte("/"))
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var rgb [3]uint16
+	for i, p := range parts {
+		if len(p) == 0 || len(p) > 4 {
+			return nil, false
+		}
+		v, err := strconv.ParseUint(string(p), 16, 16)
+		if err != nil {
+			return nil, false
+		}
+		// scale 1 to 4 hex digits to 16 bits
+		rgb[i] = uint16(v * 0xffff / (1<<(4*len(p)) - 1))
+	}
+	return color.RGBA64{rgb[0], rgb[1], rgb[2], 0xffff}, true
+}
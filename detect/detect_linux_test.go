@@ -0,0 +1,130 @@
+package detect
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the master and slave ends of a new pseudo terminal.
+func openPTY(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("cannot open /dev/ptmx: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn returned error: %v", err)
+	}
+	var n int
+	rc.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); err == nil {
+			n, err = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if err != nil {
+		t.Fatalf("cannot unlock pty: %v", err)
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("cannot open pty: %v", err)
+	}
+	t.Cleanup(func() { slave.Close() })
+	return master, slave
+}
+
+// fakeTerminal answers the queries read from master with the replies given
+// for them.
+func fakeTerminal(master *os.File, replies map[string]string) {
+	var buf []byte
+	b := make([]byte, 256)
+	for {
+		n, err := master.Read(b)
+		if err != nil {
+			return
+		}
+		buf = append(buf, b[:n]...)
+		for query, reply := range replies {
+			if i := bytes.Index(buf, []byte(query)); i >= 0 {
+				buf = append(buf[:i], buf[i+len(query):]...)
+				master.WriteString(reply)
+			}
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	master, slave := openPTY(t)
+	go fakeTerminal(master, map[string]string{
+		queryBackground: "\x1b]11;rgb:1010/2020/3030\x1b\\",
+		queryColors:     "\x1b[?1;0;256S",
+		queryGeometry:   "\x1b[?2;0;1000;1000S",
+		queryDA1:        "\x1b[?63;1;4c",
+	})
+	c, err := Query(slave, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Query returned error: %v", err)
+	}
+	if !c.Sixel || c.Class != 63 {
+		t.Fatalf("got %+v, want class 63 with sixel", c)
+	}
+	if r, g, b, _ := c.Background.RGBA(); r != 0x1010 || g != 0x2020 || b != 0x3030 {
+		t.Fatalf("background %v", c.Background)
+	}
+	if c.ColorRegisters != 256 || c.MaxWidth != 1000 || c.MaxHeight != 1000 {
+		t.Fatalf("got %d registers and %dx%d, want 256 and 1000x1000", c.ColorRegisters, c.MaxWidth, c.MaxHeight)
+	}
+}
+
+func TestSetGraphics(t *testing.T) {
+	master, slave := openPTY(t)
+	go fakeTerminal(master, map[string]string{
+		"\x1b[?1;3;1024S":      "\x1b[?1;0;1024S",
+		"\x1b[?1;1S":           "\x1b[?1;0;1024S",
+		"\x1b[?2;3;4096;4096S": "\x1b[?2;3;0S",
+		"\x1b[?2;1S":           "\x1b[?2;0;2000;2000S",
+		queryDA1:               "\x1b[?63;4c",
+	})
+	n, err := SetColorRegisters(slave, 1024, 5*time.Second)
+	if err != nil || n != 1024 {
+		t.Fatalf("SetColorRegisters returned %d, %v; want 1024", n, err)
+	}
+	var gerr *GraphicsError
+	if _, _, err := SetGeometry(slave, 4096, 4096, 5*time.Second); !errors.As(err, &gerr) || gerr.Item != 2 {
+		t.Fatalf("SetGeometry returned %v, want a GraphicsError", err)
+	}
+}
+
+func TestQueryWithoutBackground(t *testing.T) {
+	master, slave := openPTY(t)
+	go fakeTerminal(master, map[string]string{
+		queryDA1: "\x1b[?62;22c",
+	})
+	start := time.Now()
+	c, err := Query(slave, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Query returned error: %v", err)
+	}
+	if c.Sixel || c.Background != nil {
+		t.Fatalf("got %+v, want no sixel and no background", c)
+	}
+	// DA1 ends the reply without waiting for OSC 11
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Query took %v", d)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	master, slave := openPTY(t)
+	go fakeTerminal(master, nil)
+	if _, err := Query(slave, 100*time.Millisecond); !errors.Is(err, ErrNoReply) {
+		t.Fatalf("Query returned %v, want ErrNoReply", err)
+	}
+}
@@ -0,0 +1,95 @@
+package detect
+
+import (
+	"errors"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		reply string
+		ok    bool
+		want  Capabilities
+	}{
+		{
+			reply: "\x1b]11;rgb:0000/8080/ffff\x1b\\\x1b[?64;1;2;4;6;9;15;18;21;22c",
+			ok:    true,
+			want: Capabilities{
+				Class:      64,
+				Attributes: []int{1, 2, 4, 6, 9, 15, 18, 21, 22},
+				Sixel:      true,
+				Background: color.RGBA64{0, 0x8080, 0xffff, 0xffff},
+			},
+		},
+		{
+			// no OSC 11 reply and no sixel
+			reply: "\x1b[?62;22c",
+			ok:    true,
+			want:  Capabilities{Class: 62, Attributes: []int{22}},
+		},
+		{
+			// 8-bit DA1 and a short, BEL terminated OSC 11 reply
+			reply: "\x1b]11;rgb:f/80/000\x07\x9b?65;4c",
+			ok:    true,
+			want: Capabilities{
+				Class:      65,
+				Attributes: []int{4},
+				Sixel:      true,
+				Background: color.RGBA64{0xffff, 0x8080, 0, 0xffff},
+			},
+		},
+		{
+			// XTSMGRAPHICS replies and other input before DA1
+			reply: "x\x1b[?1;0;256S\x1b[?2;0;1000;800S\x1b[?1;2c",
+			ok:    true,
+			want:  Capabilities{Class: 1, Attributes: []int{2}, ColorRegisters: 256, MaxWidth: 1000, MaxHeight: 800},
+		},
+		{
+			// failed XTSMGRAPHICS requests are ignored
+			reply: "\x1b[?1;3;0S\x1b[?2;1;0S\x1b[?62;4c",
+			ok:    true,
+			want:  Capabilities{Class: 62, Attributes: []int{4}, Sixel: true},
+		},
+		{
+			reply: "\x1b[?64;4",
+		},
+		{
+			reply: "\x1b]11;rgb:ffff/ffff/ffff\x1b\\",
+			want:  Capabilities{Background: color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}},
+		},
+	} {
+		var c Capabilities
+		if ok := c.parse([]byte(tt.reply)); ok != tt.ok {
+			t.Errorf("parse(%q) reported %v, want %v", tt.reply, ok, tt.ok)
+		}
+		if !reflect.DeepEqual(c, tt.want) {
+			t.Errorf("parse(%q) = %+v, want %+v", tt.reply, c, tt.want)
+		}
+	}
+}
+
+func TestGraphicsReply(t *testing.T) {
+	b := []byte("\x1b[?1;0;16S\x1b[?2;3;0S\x1b[?1;0;256S")
+	if v, err := graphicsReply(b, itemColors); err != nil || !reflect.DeepEqual(v, []int{256}) {
+		t.Fatalf("graphicsReply returned %v, %v; want the last reply", v, err)
+	}
+	var gerr *GraphicsError
+	if _, err := graphicsReply(b, itemGeometry); !errors.As(err, &gerr) || gerr.Status != 3 {
+		t.Fatalf("graphicsReply returned %v, want status 3", err)
+	}
+	if _, err := graphicsReply([]byte("\x1b[?62c"), itemColors); err != ErrNoGraphics {
+		t.Fatalf("graphicsReply returned %v, want ErrNoGraphics", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	if p := (&Capabilities{Sixel: true}).Profile(); p != nil {
+		t.Fatalf("got %+v without XTSMGRAPHICS, want nil", p)
+	}
+	p := (&Capabilities{ColorRegisters: 16, MaxWidth: 800, MaxHeight: 480}).Profile()
+	if p == nil || p.Colors != 16 || p.MaxWidth != 800 || p.MaxHeight != 480 {
+		t.Fatalf("got %+v", p)
+	}
+}
@@ -0,0 +1,125 @@
+//go:build !windows
+
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Detect queries the controlling terminal of the process.
+func Detect() (*Capabilities, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Query(f, DefaultTimeout)
+}
+
+// Query queries the terminal f, which is put into raw mode until it has
+// replied or timeout has passed. It returns ErrNoReply, along with what was
+// read of other replies, if DA1 is not answered in time.
+func Query(f *os.File, timeout time.Duration) (*Capabilities, error) {
+	c := &Capabilities{}
+	_, err := exchange(f, queryBackground+queryColors+queryGeometry+queryDA1, timeout, c.parse)
+	return c, err
+}
+
+// SetColorRegisters asks the terminal f to provide n color registers with
+// XTSMGRAPHICS and returns the number it provides afterwards, which may be
+// less if n is above its maximum.
+func SetColorRegisters(f *os.File, n int, timeout time.Duration) (int, error) {
+	v, err := setGraphics(f, itemColors, strconv.Itoa(n), 1, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// SetGeometry asks the terminal f to accept sixel images of up to width x
+// height pixels with XTSMGRAPHICS and returns the maximum geometry
+// afterwards.
+func SetGeometry(f *os.File, width, height int, timeout time.Duration) (int, int, error) {
+	v, err := setGraphics(f, itemGeometry, fmt.Sprintf("%d;%d", width, height), 2, timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	return v[0], v[1], nil
+}
+
+// setGraphics sets the XTSMGRAPHICS item to value and returns at least n
+// values of the item read back.
+func setGraphics(f *os.File, item int, value string, n int, timeout time.Duration) ([]int, error) {
+	query := fmt.Sprintf("\x1b[?%d;3;%sS\x1b[?%d;1S", item, value, item)
+	reply, err := exchange(f, query+queryDA1, timeout, func(b []byte) bool {
+		_, ok := parseDA1(b)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the reply to the set request comes first and fails alone
+	for _, r := range csiReplies(reply, 'S') {
+		if len(r) >= 2 && r[0] == item && r[1] != 0 {
+			return nil, &GraphicsError{item, r[1]}
+		}
+	}
+	v, err := graphicsReply(reply, item)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < n {
+		return nil, &GraphicsError{item, 3}
+	}
+	return v, nil
+}
+
+// exchange writes query to the terminal f and reads the reply until done
+// reports it complete.
+func exchange(f *os.File, query string, timeout time.Duration, done func([]byte) bool) ([]byte, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var state *term.State
+	cerr := rc.Control(func(fd uintptr) {
+		state, err = term.MakeRaw(int(fd))
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Control(func(fd uintptr) {
+		term.Restore(int(fd), state)
+	})
+
+	if err := f.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	defer f.SetReadDeadline(time.Time{})
+	if _, err := f.WriteString(query); err != nil {
+		return nil, err
+	}
+
+	var reply []byte
+	var buf [256]byte
+	for !done(reply) {
+		n, err := f.Read(buf[:])
+		reply = append(reply, buf[:n]...)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return reply, ErrNoReply
+			}
+			return reply, err
+		}
+	}
+	return reply, nil
+}
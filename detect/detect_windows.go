@@ -0,0 +1,32 @@
+//go:build windows
+
+package detect
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Detect queries the controlling terminal of the process. It is not
+// supported on Windows, whose consoles have no /dev/tty to query.
+func Detect() (*Capabilities, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Query queries the terminal f. It is not supported on Windows.
+func Query(f *os.File, timeout time.Duration) (*Capabilities, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// SetColorRegisters sets the number of color registers of the terminal f.
+// It is not supported on Windows.
+func SetColorRegisters(f *os.File, n int, timeout time.Duration) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// SetGeometry sets the maximum sixel geometry of the terminal f. It is not
+// supported on Windows.
+func SetGeometry(f *os.File, width, height int, timeout time.Duration) (int, int, error) {
+	return 0, 0, errors.ErrUnsupported
+}
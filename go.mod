@@ -6,6 +6,7 @@ require (
 	github.com/BurntSushi/graphics-go v0.0.0-20160129215708-b43f31a4a966
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/soniakeys/quant v1.0.0
+	golang.org/x/image v0.25.0
 	golang.org/x/term v0.38.0
 )
 
@@ -13,5 +14,5 @@ require github.com/mattn/go-isatty v0.0.20 // indirect
 
 require (
 	github.com/mattn/go-tty/v2 v2.0.1
-	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/sys v0.39.0
 )
@@ -0,0 +1,149 @@
+// Package capture reads recorded terminal sessions: raw typescript files
+// written by script(1) and asciinema v2 cast files.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Recording is the output of a terminal session.
+type Recording struct {
+	// Data holds everything written to the terminal, in order.
+	Data []byte
+
+	// Events holds the start of each output event of a cast, in order. It
+	// is nil for a typescript, which has no timing.
+	Events []Event
+
+	// Width and Height are the size of the terminal in columns and rows
+	// from the header of a cast, or 0 if unknown.
+	Width, Height int
+}
+
+// Event is a chunk of output written at one time.
+type Event struct {
+	// Time is the number of seconds since the start of the recording.
+	Time float64
+
+	// Offset is the position of the first byte of the event in Data.
+	Offset int64
+}
+
+var (
+	scriptStarted = []byte("Script started on ")
+	scriptDone    = []byte("Script done on ")
+)
+
+// Read reads a recording from r. A stream starting with an asciinema v2
+// header is read as a cast; anything else is taken as a typescript, whose
+// "Script started" and "Script done" lines are dropped.
+func Read(r io.Reader) (*Recording, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimLeft(b, " \t\r\n"), []byte("{")) {
+		var header struct {
+			Version int `json:"version"`
+			Width   int `json:"width"`
+			Height  int `json:"height"`
+		}
+		line, _, _ := bytes.Cut(b, []byte("\n"))
+		if json.Unmarshal(line, &header) == nil {
+			if header.Version != 2 {
+				return nil, fmt.Errorf("capture: unsupported cast version %d", header.Version)
+			}
+			rec, err := readCast(b)
+			if err != nil {
+				return nil, err
+			}
+			rec.Width, rec.Height = header.Width, header.Height
+			return rec, nil
+		}
+	}
+	return readTypescript(b), nil
+}
+
+func readTypescript(b []byte) *Recording {
+	if bytes.HasPrefix(b, scriptStarted) {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[i+1:]
+		} else {
+			b = nil
+		}
+	}
+	if i := bytes.LastIndex(b, scriptDone); i >= 0 && (i == 0 || b[i-1] == '\n') {
+		b = b[:i]
+	}
+	return &Recording{Data: b}
+}
+
+func readCast(b []byte) (*Recording, error) {
+	rec := &Recording{Events: []Event{}}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, len(b)+1)
+	s.Scan() // header
+	line := 1
+	for s.Scan() {
+		line++
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var ev []json.RawMessage
+		if err := json.Unmarshal(s.Bytes(), &ev); err != nil || len(ev) != 3 {
+			return nil, fmt.Errorf("capture: invalid event on line %d", line)
+		}
+		var t float64
+		var code, data string
+		if json.Unmarshal(ev[0], &t) != nil || json.Unmarshal(ev[1], &code) != nil || json.Unmarshal(ev[2], &data) != nil {
+			return nil, fmt.Errorf("capture: invalid event on line %d", line)
+		}
+		if code != "o" {
+			continue
+		}
+		rec.Events = append(rec.Events, Event{Time: t, Offset: int64(len(rec.Data))})
+		rec.Data = append(rec.Data, data...)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// ErrNoTiming is returned by Time for a recording without timing.
+var ErrNoTiming = errors.New("capture: recording has no timing")
+
+// Time returns the time of the event that wrote the byte at offset off of
+// Data.
+func (r *Recording) Time(off int64) (float64, error) {
+	if r.Events == nil {
+		return 0, ErrNoTiming
+	}
+	i := sort.Search(len(r.Events), func(i int) bool {
+		return r.Events[i].Offset > off
+	})
+	if i == 0 {
+		return 0, nil
+	}
+	return r.Events[i-1].Time, nil
+}
+
+// Until returns the part of Data written up to time t.
+func (r *Recording) Until(t float64) ([]byte, error) {
+	if r.Events == nil {
+		return nil, ErrNoTiming
+	}
+	i := sort.Search(len(r.Events), func(i int) bool {
+		return r.Events[i].Time > t
+	})
+	if i == len(r.Events) {
+		return r.Data, nil
+	}
+	return r.Data[:r.Events[i].Offset], nil
+}
@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadTypescript(t *testing.T) {
+	in := "Script started on 2024-01-01 10:00:00+00:00 [TERM=\"xterm\"]\n" +
+		"$ gosr a.png\r\n\x1bPq#0;2;0;0;0~-\x1b\\\r\n$ exit\r\n" +
+		"\nScript done on 2024-01-01 10:00:05+00:00 [COMMAND_EXIT_CODE=\"0\"]\n"
+	rec, err := Read(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	want := "$ gosr a.png\r\n\x1bPq#0;2;0;0;0~-\x1b\\\r\n$ exit\r\n\n"
+	if string(rec.Data) != want {
+		t.Fatalf("data %q, want %q", rec.Data, want)
+	}
+	if _, err := rec.Time(0); err != ErrNoTiming {
+		t.Fatalf("Time returned %v, want ErrNoTiming", err)
+	}
+	if _, err := rec.Until(0); err != ErrNoTiming {
+		t.Fatalf("Until returned %v, want ErrNoTiming", err)
+	}
+}
+
+func TestReadCast(t *testing.T) {
+	in := `{"version": 2, "width": 80, "height": 24, "timestamp": 1704103200}
+[0.5, "o", "$ "]
+[1.25, "i", "x"]
+[1.5, "o", "\u001bPq"]
+[1.75, "r", "100x40"]
+[2.0, "o", "#0;2;0;0;0~-\u001b\\"]
+`
+	rec, err := Read(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if want := "$ \x1bPq#0;2;0;0;0~-\x1b\\"; string(rec.Data) != want {
+		t.Fatalf("data %q, want %q", rec.Data, want)
+	}
+	for _, tt := range []struct {
+		off  int64
+		want float64
+	}{
+		{0, 0.5},
+		{1, 0.5},
+		{2, 1.5},
+		{4, 1.5},
+		{5, 2.0},
+		{100, 2.0},
+	} {
+		got, err := rec.Time(tt.off)
+		if err != nil {
+			t.Fatalf("Time returned error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Time(%d) = %v, want %v", tt.off, got, tt.want)
+		}
+	}
+	if rec.Width != 80 || rec.Height != 24 {
+		t.Fatalf("size %dx%d, want 80x24", rec.Width, rec.Height)
+	}
+	for _, tt := range []struct {
+		t    float64
+		want string
+	}{
+		{0, ""},
+		{0.5, "$ "},
+		{1.75, "$ \x1bPq"},
+		{10, "$ \x1bPq#0;2;0;0;0~-\x1b\\"},
+	} {
+		got, err := rec.Until(tt.t)
+		if err != nil {
+			t.Fatalf("Until returned error: %v", err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Until(%v) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestReadCastErrors(t *testing.T) {
+	for _, in := range []string{
+		"{\"version\": 1}\n",
+		"{\"version\": 2}\n[0.5, \"o\"]\n",
+		"{\"version\": 2}\n[\"x\", \"o\", \"a\"]\n",
+		"{\"version\": 2}\nnot json\n",
+	} {
+		if _, err := Read(strings.NewReader(in)); err == nil {
+			t.Errorf("Read(%q) returned no error", in)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package sixel
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// OptimizeOptions configures Optimize.
+type OptimizeOptions struct {
+	// Lenient decodes images as Decoder.Lenient does, so images with
+	// errors are rewritten the way terminals show them instead of being
+	// copied unchanged along with the rest of the stream.
+	Lenient bool
+}
+
+// Optimize copies the sixel stream r to w, rewriting each image with as
+// little data as possible: only the color registers used are defined,
+// registers of the same color are merged and the sixel data is run-length
+// compressed again. Registers the image uses without defining them keep
+// their number and stay undefined, so they show the color the terminal
+// holds as before. The DCS parameters and raster attributes are kept.
+//
+// Text between images is copied unchanged. So is every image whose
+// rewrite would not be smaller or would not decode to exactly the same
+// pixels, for instance one using more than 256 registers or changing the
+// color of a register after painting with it, and everything from the
+// first image that cannot be decoded on. opts may be nil.
+func Optimize(r io.Reader, w io.Writer, opts *OptimizeOptions) error {
+	rec := &recorder{r: r}
+	dec := NewDecoder(rec)
+	dec.Paletted = true
+	// Unpainted pixels stay unpainted in the rewrite, so the terminal
+	// fills them as before.
+	dec.noFill = true
+	if opts != nil {
+		dec.Lenient = opts.Lenient
+	}
+	var o optimizer
+	for {
+		rec.discard(dec.offset())
+		var img image.Image
+		var text []byte
+		start, err := dec.decode(&img, &text)
+		if _, err := w.Write(text); err != nil {
+			return err
+		}
+		if err != nil {
+			var se *SyntaxError
+			var le *LimitError
+			if !errors.As(err, &se) && !errors.As(err, &le) {
+				return err
+			}
+			// copy the rest of the stream as it is
+			if _, err := w.Write(rec.since(start)); err != nil {
+				return err
+			}
+			_, err = io.Copy(w, r)
+			return err
+		}
+		if img == nil {
+			return nil
+		}
+		raw := rec.since(start)[:dec.offset()-start]
+		out := raw
+		// the paletted image shows every pixel in the last color of its
+		// register, so it only holds the right colors if none changed
+		if !dec.redefined {
+			if rw := o.rewrite(img, dec.header, dec.info, len(raw)); rw != nil {
+				out = rw
+			}
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+}
+
+// recorder keeps the bytes read from r from offset base on.
+type recorder struct {
+	r    io.Reader
+	buf  []byte
+	base int64
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// since returns the bytes read from offset off on.
+func (r *recorder) since(off int64) []byte {
+	return r.buf[off-r.base:]
+}
+
+// discard forgets the bytes before offset off.
+func (r *recorder) discard(off int64) {
+	n := copy(r.buf, r.buf[off-r.base:])
+	r.buf = r.buf[:n]
+	r.base = off
+}
+
+// optimizer rewrites images, keeping its buffers across them.
+type optimizer struct {
+	bands sixelBands
+	out   []byte
+	pix   []uint8
+}
+
+// rewrite returns the shortest encoding of img, decoded with header h and
+// info, if it is shorter than limit bytes and decodes to the same pixels,
+// or nil.
+func (o *optimizer) rewrite(img image.Image, h Header, info Info, limit int) []byte {
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		return nil
+	}
+
+	// Registers painted with but not defined show whatever color the
+	// terminal holds, so they keep their number and stay undefined. With
+	// P2=0 or 2 the terminal may fill the background with register 0, so
+	// it is kept as well, or else keeps its color.
+	var used, own, keep [256]bool
+	for _, v := range p.Pix {
+		used[v] = true
+	}
+	for _, r := range info.Registers {
+		if r.N < len(keep) {
+			own[r.N] = r.Defined
+			keep[r.N] = r.Used && !r.Defined
+		}
+	}
+	if h.P2 != 1 {
+		used[0] = true
+		keep[0] = !own[0]
+	}
+	var palette color.Palette
+	var remap [256]uint8
+	for i := range p.Palette {
+		if keep[i] {
+			for len(palette) <= i {
+				palette = append(palette, color.NRGBA{})
+			}
+			palette[i] = p.Palette[i]
+			remap[i] = uint8(i)
+		}
+	}
+	// The other registers are mapped to new ones, one per color, around
+	// those kept.
+	next := 0
+	alloc := func(c color.NRGBA) int {
+		for next < len(palette) && keep[next] {
+			next++
+		}
+		if next == len(palette) {
+			palette = append(palette, c)
+		} else {
+			palette[next] = c
+		}
+		next++
+		return next - 1
+	}
+	index := make(map[color.NRGBA]int)
+	hasTransparent := false
+	for i, c := range p.Palette {
+		if !used[i] || keep[i] {
+			continue
+		}
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if nc.A == 0 {
+			hasTransparent = true
+			continue
+		}
+		n, ok := index[nc]
+		if !ok {
+			n = alloc(nc)
+			index[nc] = n
+		}
+		remap[i] = uint8(n)
+	}
+	if hasTransparent {
+		t := alloc(color.NRGBA{})
+		for i, c := range p.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				remap[i] = uint8(t)
+			}
+		}
+	}
+	if len(palette) > 256 {
+		return nil
+	}
+	if cap(o.pix) < len(p.Pix) {
+		o.pix = make([]uint8, len(p.Pix))
+	}
+	pix := o.pix[:len(p.Pix)]
+	for i, v := range p.Pix {
+		pix[i] = remap[v]
+	}
+
+	out := o.out[:0]
+	// DECSIXEL Introducer(\033PP1;P2;P3q), leaving out trailing zeros
+	out = append(out, 0x1b, 'P')
+	params := []int{h.P1, h.P2, h.P3}
+	for len(params) > 0 && params[len(params)-1] == 0 {
+		params = params[:len(params)-1]
+	}
+	for i, v := range params {
+		if i > 0 {
+			out = append(out, ';')
+		}
+		out = strconv.AppendInt(out, int64(v), 10)
+	}
+	out = append(out, 'q')
+	if h.RasterAttributes {
+		// DECGRA ("Pan;Pad;Ph;Pv): Set Raster Attributes
+		out = append(out, '"')
+		out = strconv.AppendInt(out, int64(h.Pan), 10)
+		out = append(out, ';')
+		out = strconv.AppendInt(out, int64(h.Pad), 10)
+		if h.Ph != 0 || h.Pv != 0 {
+			out = append(out, ';')
+			out = strconv.AppendInt(out, int64(h.Ph), 10)
+			out = append(out, ';')
+			out = strconv.AppendInt(out, int64(h.Pv), 10)
+		}
+	}
+	for n, c := range palette {
+		r, g, b, a := c.RGBA()
+		if a == 0 || keep[n] {
+			continue
+		}
+		r = (r*100 + 0x7FFF) / 0xFFFF
+		g = (g*100 + 0x7FFF) / 0xFFFF
+		b = (b*100 + 0x7FFF) / 0xFFFF
+		out = appendColorRegister(out, n, r, g, b)
+	}
+	width, height := p.Rect.Dx(), p.Rect.Dy()
+	o.bands.reset(palette, width)
+	var rows [6][]uint8
+	for z := 0; z < height; z += 6 {
+		n := 0
+		for ; n < 6 && z+n < height; n++ {
+			off := (z + n) * p.Stride
+			rows[n] = pix[off : off+width]
+		}
+		out = o.bands.append(out, rows[:n])
+	}
+	// string terminator(ST)
+	out = append(out, 0x1b, 0x5c)
+	o.out = out
+	if len(out) >= limit {
+		return nil
+	}
+
+	// make sure nothing changed
+	dec := NewDecoder(bytes.NewReader(out))
+	dec.Paletted = true
+	dec.noFill = true
+	var check image.Image
+	if err := dec.Decode(&check); err != nil || dec.Header() != h || !samePixels(p, check) {
+		return nil
+	}
+	return out
+}
+
+// samePixels reports whether a and b have the same bounds and colors.
+func samePixels(a *image.Paletted, b image.Image) bool {
+	pb, ok := b.(*image.Paletted)
+	if !ok || pb.Rect != a.Rect {
+		return false
+	}
+	for i, v := range a.Pix {
+		ca := color.NRGBAModel.Convert(a.Palette[v]).(color.NRGBA)
+		cb := color.NRGBAModel.Convert(pb.Palette[pb.Pix[i]]).(color.NRGBA)
+		if ca.A == 0 && cb.A == 0 {
+			continue
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package sixel
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// decodeAllImages decodes every image of s ignoring P2, as the terminal
+// fill is not part of the data.
+func decodeAllImages(t *testing.T, s string) []image.Image {
+	t.Helper()
+	var images []image.Image
+	dec := NewDecoder(strings.NewReader(s))
+	dec.Paletted = true
+	dec.noFill = true
+	for {
+		seg, err := dec.Next()
+		if err != nil {
+			break
+		}
+		if seg.Image != nil {
+			images = append(images, seg.Image)
+		}
+	}
+	return images
+}
+
+func TestOptimize(t *testing.T) {
+	// unused and duplicate registers, no run-length compression
+	bloated := "\x1bP0;1;0q\"1;1;8;6" +
+		"#0;2;0;0;0#1;2;100;0;0#2;2;100;0;0#3;2;0;0;100#4;2;50;50;50" +
+		"#1~~~~$#2????~~~~$#3" + strings.Repeat("?", 8) + "\x1b\\"
+	src := image.NewPaletted(image.Rect(0, 0, 40, 20), color.Palette{color.NRGBA{0, 0, 0xFF, 0xFF}, color.NRGBA{0xFF, 0xFF, 0, 0xFF}})
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i / 7 % 2)
+	}
+	var encoded bytes.Buffer
+	if err := NewEncoder(&encoded).Encode(src); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	minimal := "\x1bP0;1q~\x1b\\"
+
+	input := "$ cat a.six\r\n" + bloated + "\x1b[0m\r\n" + encoded.String() + minimal + "$ "
+	var out bytes.Buffer
+	if err := Optimize(strings.NewReader(input), &out, nil); err != nil {
+		t.Fatalf("Optimize returned error: %v", err)
+	}
+	if out.Len() >= len(input) {
+		t.Fatalf("Optimize wrote %d bytes for %d", out.Len(), len(input))
+	}
+	got := out.String()
+	for _, s := range []string{"$ cat a.six\r\n", "\x1b[0m\r\n", minimal + "$ "} {
+		if !strings.Contains(got, s) {
+			t.Errorf("output %q lacks %q", got, s)
+		}
+	}
+	if strings.Contains(got, "#4;") || strings.Contains(got, "#2;") {
+		t.Errorf("output %q defines unused or duplicate registers", got)
+	}
+
+	want := decodeAllImages(t, input)
+	have := decodeAllImages(t, got)
+	if len(have) != len(want) {
+		t.Fatalf("got %d images, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if !samePixels(want[i].(*image.Paletted), have[i]) {
+			t.Errorf("image %d changed", i)
+		}
+	}
+}
+
+func TestOptimizeRedefinedRegister(t *testing.T) {
+	// register 1 paints red and then green, so the image is copied as is
+	redefined := "\x1bP0;1q\"1;1;2;6#1;2;100;0;0#1~#1;2;0;100;0#1~\x1b\\"
+	input := redefined + "\x1bP0;1q\"1;1;2;6#1;2;100;0;0#1~#1;2;100;0;0#1~\x1b\\"
+	var out bytes.Buffer
+	if err := Optimize(strings.NewReader(input), &out, nil); err != nil {
+		t.Fatalf("Optimize returned error: %v", err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, redefined) || len(got) >= len(input) {
+		t.Fatalf("got %q, want the first image copied and the second rewritten", got)
+	}
+	var img image.Image
+	dec := NewDecoder(&out)
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	for x, want := range []color.NRGBA{{0xFF, 0, 0, 0xFF}, {0, 0xFF, 0, 0xFF}} {
+		if got := color.NRGBAModel.Convert(img.At(x, 0)); got != want {
+			t.Errorf("pixel %d is %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestOptimizeUndefinedRegister(t *testing.T) {
+	// registers 0, the background, and 3 are never defined, so they keep
+	// whatever color the terminal holds; red goes to another register
+	input := "\x1bPq\"1;1;6;6#1;2;0;0;100#2;2;100;0;0#4;2;100;0;0#3~~#2~~#4~\x1b\\"
+	var out bytes.Buffer
+	if err := Optimize(strings.NewReader(input), &out, nil); err != nil {
+		t.Fatalf("Optimize returned error: %v", err)
+	}
+	got := out.String()
+	if len(got) >= len(input) {
+		t.Fatalf("got %q, want the image rewritten", got)
+	}
+	dec := NewDecoder(strings.NewReader(got))
+	var img image.Image
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	var regs []string
+	for _, r := range dec.Info().Registers {
+		regs = append(regs, fmt.Sprintf("%d:%v:%v", r.N, r.Defined, r.Used))
+	}
+	if got, want := strings.Join(regs, " "), "1:true:true 3:false:true"; got != want {
+		t.Fatalf("got registers %s, want %s in %q", got, want, out.String())
+	}
+
+	// painting before defining a register shows the terminal color first,
+	// even if the definition matches the VT340 one
+	input = "\x1bPq#1~~#1;2;20;20;80#0;2;0;0;0#2;2;0;0;0#1~~\x1b\\"
+	out.Reset()
+	if err := Optimize(strings.NewReader(input), &out, nil); err != nil {
+		t.Fatalf("Optimize returned error: %v", err)
+	}
+	if out.String() != input {
+		t.Fatalf("got %q, want the image copied", out.String())
+	}
+}
+
+func TestOptimizeInvalid(t *testing.T) {
+	valid := "\x1bP0;1q\"1;1;4;6#1;2;100;0;0#2;2;0;0;0~~~~\x1b\\"
+	invalid := "\x1bPq#1;2;100;0;0~ ~\x1b\\ after"
+	var out bytes.Buffer
+	if err := Optimize(strings.NewReader(valid+"text"+invalid), &out, nil); err != nil {
+		t.Fatalf("Optimize returned error: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "text"+invalid) {
+		t.Fatalf("got %q, want the invalid image and the rest copied", out.String())
+	}
+	if out.Len() >= len(valid+"text"+invalid) {
+		t.Fatalf("valid image was not rewritten: %q", out.String())
+	}
+
+	// Lenient rewrites it as shown by terminals
+	out.Reset()
+	invalid = "\x1bPq#1;2;100;0;0" + strings.Repeat("~", 20) + " " + strings.Repeat("~", 20) + "\x1b\\ after"
+	if err := Optimize(strings.NewReader(invalid), &out, &OptimizeOptions{Lenient: true}); err != nil {
+		t.Fatalf("Optimize returned error: %v", err)
+	}
+	if got := out.String(); !strings.HasSuffix(got, " after") || strings.Contains(got, "~ ~") {
+		t.Fatalf("got %q", got)
+	}
+}
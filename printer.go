@@ -0,0 +1,264 @@
+package sixel
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Printer describes a monochrome sixel printer.
+type Printer struct {
+	// Name is the lower case name used by LookupPrinter.
+	Name string
+
+	// Aspect is the DCS P1 parameter selecting the pixel aspect ratio:
+	// 0, 1, 5 and 6 select 2:1 (pixels twice as tall as wide), 2 selects
+	// 5:1, 3 and 4 select 3:1 and 7, 8 and 9 select 1:1.
+	Aspect int
+
+	// PageWidth and PageHeight are the printable area of a page in pixels.
+	// Wider images wrap into strips printed below each other and longer
+	// ones continue on the next page after a form feed.
+	PageWidth  int
+	PageHeight int
+
+	// RasterAttributes reports whether the printer understands DECGRA, in
+	// which case the aspect ratio and size are sent with it as well.
+	RasterAttributes bool
+}
+
+// Built-in printers. Their page sizes are US letter at the sixel
+// resolution of the printer.
+var (
+	// PrinterLA50 is the DEC LA50 dot matrix printer: 144 by 72 dpi.
+	PrinterLA50 = &Printer{
+		Name:       "la50",
+		Aspect:     0,
+		PageWidth:  1152,
+		PageHeight: 792,
+	}
+	// PrinterLN03 is the DEC LN03 laser printer at 150 dpi sixels.
+	PrinterLN03 = &Printer{
+		Name:             "ln03",
+		Aspect:           9,
+		PageWidth:        1200,
+		PageHeight:       1650,
+		RasterAttributes: true,
+	}
+)
+
+// Printers lists the built-in printers.
+var Printers = []*Printer{
+	PrinterLA50,
+	PrinterLN03,
+}
+
+// LookupPrinter returns the built-in printer with the given name, ignoring
+// case. It returns nil if there is none.
+func LookupPrinter(name string) *Printer {
+	for _, p := range Printers {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// pixelAspect returns the height of a pixel relative to its width for the
+// DCS P1 parameter aspect.
+func pixelAspect(aspect int) int {
+	switch aspect {
+	case 2:
+		return 5
+	case 3, 4:
+		return 3
+	case 7, 8, 9:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// PrinterEncoder encodes images for monochrome sixel printers. Pixels are
+// reduced to one bit and printed without color introducers.
+type PrinterEncoder struct {
+	w       io.Writer
+	printer *Printer
+
+	// Dither, if true, dithers the gray levels with the Floyd–Steinberg
+	// algorithm instead of thresholding them.
+	Dither bool
+
+	// Threshold is the gray level (0-255) below which a pixel is printed
+	// when not dithering. If zero, 128 is used.
+	Threshold uint8
+
+	pages int
+}
+
+// NewPrinterEncoder returns a PrinterEncoder writing to w for printer p.
+func NewPrinterEncoder(w io.Writer, p *Printer) *PrinterEncoder {
+	return &PrinterEncoder{w: w, printer: p}
+}
+
+// Encode prints img. Images are scaled vertically to make up for the pixel
+// aspect ratio of the printer, so they keep their proportions on paper.
+// Every page after the first, including those of earlier calls, is
+// preceded by a form feed.
+func (e *PrinterEncoder) Encode(img image.Image) error {
+	bits, width, height := e.bitmap(img)
+	if width == 0 || height == 0 {
+		return nil
+	}
+	p := e.printer
+	pageWidth := p.PageWidth
+	if pageWidth <= 0 || pageWidth > width {
+		pageWidth = width
+	}
+	strips := (width + pageWidth - 1) / pageWidth
+	total := strips * height
+	pageHeight := p.PageHeight
+	if pageHeight <= 0 || pageHeight > total {
+		pageHeight = total
+	}
+	if pageHeight > 6 {
+		pageHeight -= pageHeight % 6
+	}
+
+	// at returns the sixel of six rows starting at virtual row r, in which
+	// the strips of a wide image follow each other.
+	at := func(r, x int) byte {
+		var ch byte
+		for i := 0; i < 6 && r+i < total; i++ {
+			strip, y := (r+i)/height, (r+i)%height
+			sx := strip*pageWidth + x
+			if sx < width && bits[y*width+sx] != 0 {
+				ch |= 1 << uint(i)
+			}
+		}
+		return ch
+	}
+
+	var out []byte
+	for top := 0; top < total; top += pageHeight {
+		if e.pages > 0 {
+			out = append(out, '\f')
+		}
+		e.pages++
+		rows := min(pageHeight, total-top)
+		out = e.appendHeader(out, pageWidth, rows)
+		for z := 0; z < rows; z += 6 {
+			// DECGNL (-): Graphics Next Line
+			if z > 0 {
+				out = append(out, '-')
+			}
+			ch0, cnt := byte(0), 0
+			for x := 0; x < pageWidth; x++ {
+				ch := at(top+z, x)
+				if z+6 > rows {
+					// The last band of a page must not print the
+					// rows of the next page.
+					ch &= 1<<uint(rows-z) - 1
+				}
+				if ch != ch0 {
+					out = appendRun(out, ch0, cnt)
+					ch0, cnt = ch, 0
+				}
+				cnt++
+			}
+			// trailing blank sixels are left out
+			if ch0 != 0 {
+				out = appendRun(out, ch0, cnt)
+			}
+		}
+		// string terminator(ST)
+		out = append(out, 0x1b, 0x5c)
+		if _, err := e.w.Write(out); err != nil {
+			return err
+		}
+		out = out[:0]
+	}
+	return nil
+}
+
+func (e *PrinterEncoder) appendHeader(out []byte, width, height int) []byte {
+	// DECSIXEL Introducer(\033PP1;1q): P2=1 leaves 0 bits unprinted.
+	out = append(out, 0x1b, 'P')
+	out = strconv.AppendInt(out, int64(e.printer.Aspect), 10)
+	out = append(out, ";1q"...)
+	if e.printer.RasterAttributes {
+		// DECGRA ("Pan;Pad;Ph;Pv): Set Raster Attributes
+		out = append(out, '"')
+		out = strconv.AppendInt(out, int64(pixelAspect(e.printer.Aspect)), 10)
+		out = append(out, ";1;"...)
+		out = strconv.AppendInt(out, int64(width), 10)
+		out = append(out, ';')
+		out = strconv.AppendInt(out, int64(height), 10)
+	}
+	return out
+}
+
+// bitmap returns img as one byte per pixel, 1 where a dot is printed,
+// scaled vertically by the pixel aspect ratio of the printer.
+func (e *PrinterEncoder) bitmap(img image.Image) ([]byte, int, int) {
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(gray, b, img, b.Min, draw.Over)
+
+	aspect := pixelAspect(e.printer.Aspect)
+	width, height := b.Dx(), (b.Dy()+aspect-1)/aspect
+	// average each group of aspect source rows into one printer row
+	levels := make([]int32, width*height)
+	for y := 0; y < height; y++ {
+		n := int32(0)
+		for sy := y * aspect; sy < min((y+1)*aspect, b.Dy()); sy++ {
+			o := gray.PixOffset(b.Min.X, b.Min.Y+sy)
+			row := gray.Pix[o : o+width]
+			for x, v := range row {
+				levels[y*width+x] += int32(v)
+			}
+			n++
+		}
+		for x := 0; x < width; x++ {
+			levels[y*width+x] /= n
+		}
+	}
+
+	threshold := int32(e.Threshold)
+	if threshold == 0 {
+		threshold = 128
+	}
+	bits := make([]byte, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			v, out := levels[i], int32(255)
+			if v < threshold {
+				bits[i] = 1
+				out = 0
+			}
+			if !e.Dither {
+				continue
+			}
+			// Floyd–Steinberg error diffusion over the gray levels
+			err := v - out
+			if x+1 < width {
+				levels[i+1] += err * 7 / 16
+			}
+			if y+1 < height {
+				if x > 0 {
+					levels[i+width-1] += err * 3 / 16
+				}
+				levels[i+width] += err * 5 / 16
+				if x+1 < width {
+					levels[i+width+1] += err / 16
+				}
+			}
+		}
+	}
+	return bits, width, height
+}
@@ -0,0 +1,72 @@
+package sixel
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPrinterEncoderAspect(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 12))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 12; y++ {
+			if x < 2 {
+				img.SetGray(x, y, color.Gray{0xFF})
+			}
+		}
+	}
+	var out bytes.Buffer
+	if err := NewPrinterEncoder(&out, PrinterLA50).Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	// 12 rows of 2:1 pixels print as a single band of six.
+	if got, want := out.String(), "\x1bP0;1q??~~\x1b\\"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrinterEncoderPages(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 12))
+	p := &Printer{Aspect: 9, PageWidth: 4, PageHeight: 6, RasterAttributes: true}
+
+	var out bytes.Buffer
+	enc := NewPrinterEncoder(&out, p)
+	if err := enc.Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	// Two strips of 4x12 make 24 rows, printed on four pages.
+	page := "\x1bP9;1q\"1;1;4;6!4~\x1b\\"
+	want := page + "\f" + page + "\f" + page + "\f" + page
+	if got := out.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := enc.Encode(image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("\f")) {
+		t.Fatalf("second image does not start a new page")
+	}
+}
+
+func TestPrinterEncoderDither(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 60, 60))
+	for i := range gray.Pix {
+		gray.Pix[i] = 0x80
+	}
+	var out bytes.Buffer
+	enc := NewPrinterEncoder(&out, PrinterLN03)
+	enc.Dither = true
+	if err := enc.Encode(gray); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	if bytes.ContainsRune(out.Bytes(), '#') {
+		t.Fatalf("printer output contains color introducers")
+	}
+	// A 50% gray must come out as a pattern, not solid or blank.
+	if bytes.Contains(out.Bytes(), []byte("!60~")) || !bytes.ContainsAny(out.Bytes(), "@ABCDEFGHIJKLMNOPQRSTUVWXYZ[]^_`abcdefghijklmnopqrstuvwxyz{|}") {
+		t.Fatalf("dithered output is not a pattern: %q", out.Bytes())
+	}
+}
@@ -0,0 +1,137 @@
+package sixel
+
+import "strings"
+
+// Profile describes the sixel limits of a terminal. A zero value for a
+// numeric limit means the terminal has no known limit.
+type Profile struct {
+	// Name is the lower case name used by LookupProfile.
+	Name string
+
+	// Colors is the number of color registers the terminal provides.
+	Colors int
+
+	// MaxWidth and MaxHeight are the largest image the terminal draws.
+	// Pixels beyond them are clipped by the terminal.
+	MaxWidth  int
+	MaxHeight int
+
+	// HLS reports whether HLS color introducers (#Pc;1;...) are understood.
+	HLS bool
+
+	// RasterAttributes reports whether DECGRA ("Pan;Pad;Ph;Pv) is honored
+	// for the image size.
+	RasterAttributes bool
+
+	// AspectRatio reports whether the pixel aspect ratio from DECGRA or the
+	// DCS P1 parameter is honored. Terminals that ignore it draw square
+	// pixels.
+	AspectRatio bool
+
+	// EightBit reports whether 8-bit C1 controls (DCS 0x90, ST 0x9C) are
+	// accepted in addition to their 7-bit forms.
+	EightBit bool
+}
+
+// Built-in profiles for the terminals listed in the README. The values are
+// the defaults of each terminal; most of them can be raised by configuration.
+var (
+	ProfileVT340 = &Profile{
+		Name:             "vt340",
+		Colors:           16,
+		MaxWidth:         800,
+		MaxHeight:        480,
+		HLS:              true,
+		RasterAttributes: true,
+		AspectRatio:      true,
+		EightBit:         true,
+	}
+	ProfileXterm = &Profile{
+		Name:             "xterm",
+		Colors:           1024,
+		MaxWidth:         1000,
+		MaxHeight:        1000,
+		HLS:              true,
+		RasterAttributes: true,
+		EightBit:         true,
+	}
+	ProfileMlterm = &Profile{
+		Name:             "mlterm",
+		Colors:           256,
+		HLS:              true,
+		RasterAttributes: true,
+		EightBit:         true,
+	}
+	ProfileFoot = &Profile{
+		Name:             "foot",
+		Colors:           1024,
+		MaxWidth:         10000,
+		MaxHeight:        10000,
+		HLS:              true,
+		RasterAttributes: true,
+		AspectRatio:      true,
+	}
+	ProfileWezTerm = &Profile{
+		Name:             "wezterm",
+		Colors:           256,
+		HLS:              true,
+		RasterAttributes: true,
+	}
+	ProfileWindowsTerminal = &Profile{
+		Name:             "windows-terminal",
+		Colors:           256,
+		HLS:              true,
+		RasterAttributes: true,
+		AspectRatio:      true,
+	}
+	ProfileMintty = &Profile{
+		Name:             "mintty",
+		Colors:           256,
+		HLS:              true,
+		RasterAttributes: true,
+	}
+)
+
+// Profiles lists the built-in profiles.
+var Profiles = []*Profile{
+	ProfileVT340,
+	ProfileXterm,
+	ProfileMlterm,
+	ProfileFoot,
+	ProfileWezTerm,
+	ProfileWindowsTerminal,
+	ProfileMintty,
+}
+
+// LookupProfile returns the built-in profile with the given name, ignoring
+// case. It returns nil if there is none.
+func LookupProfile(name string) *Profile {
+	for _, p := range Profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// clampColors limits nc to the color registers of p.
+func (p *Profile) clampColors(nc int) int {
+	if p != nil && p.Colors > 0 && nc > p.Colors {
+		return p.Colors
+	}
+	return nc
+}
+
+// clampSize limits width and height to the maximum geometry of p.
+func (p *Profile) clampSize(width, height int) (int, int) {
+	if p == nil {
+		return width, height
+	}
+	if p.MaxWidth > 0 && width > p.MaxWidth {
+		width = p.MaxWidth
+	}
+	if p.MaxHeight > 0 && height > p.MaxHeight {
+		height = p.MaxHeight
+	}
+	return width, height
+}
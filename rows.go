@@ -0,0 +1,255 @@
+package sixel
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"github.com/soniakeys/quant/median"
+)
+
+// RowSource supplies an image one row at a time, so EncodeRows can encode
+// images too large to hold in memory, e.g. from a streaming decoder.
+type RowSource interface {
+	// Size returns the width and height of the image in pixels.
+	Size() (width, height int)
+
+	// ReadRow reads the next row into dst as non-premultiplied RGBA,
+	// 4 bytes per pixel. It returns io.EOF when no rows are left.
+	ReadRow(dst []byte) error
+
+	// Rewind makes the next ReadRow return the first row again.
+	Rewind() error
+}
+
+// EncodeRows encodes the image supplied by src. It reads src twice: once to
+// build the palette from a sample of the pixels, and once to encode it band
+// by band, writing each band as soon as it is done. Memory use is
+// proportional to the width of the image, not its area.
+//
+// Width, Height, Colors, Dither, Transparent and Profile apply as for
+// Encode; the weight and tiling options are ignored.
+func (e *Encoder) EncodeRows(src RowSource) error {
+	nc := e.colors()
+	srcWidth, srcHeight := src.Size()
+	if srcWidth == 0 || srcHeight == 0 {
+		return nil
+	}
+	width, height := srcWidth, srcHeight
+	if e.Width > 0 {
+		width = e.Width
+	}
+	if e.Height > 0 {
+		height = e.Height
+	}
+	width, height = e.Profile.clampSize(width, height)
+	srcHeight = min(srcHeight, height)
+
+	row := make([]byte, 4*srcWidth)
+	m, err := newRowMapper(src, row, srcHeight, nc-1, e.Dither)
+	if err != nil {
+		return err
+	}
+	if err := src.Rewind(); err != nil {
+		return err
+	}
+
+	out := e.appendHeader(e.outScratch[:0], m.palette, width, height)
+	e.bands.reset(m.palette, width)
+	var idx [6][]uint8
+	for p := range idx {
+		idx[p] = make([]uint8, min(srcWidth, width))
+	}
+	for z := 0; z < (height+5)/6; z++ {
+		n := 0
+		for p := 0; p < 6 && z*6+p < srcHeight; p++ {
+			if err := readRow(src, row); err != nil {
+				return err
+			}
+			m.mapRow(idx[p], row)
+			n++
+		}
+		out = e.bands.append(out, idx[:n])
+		if _, err := e.w.Write(out); err != nil {
+			return err
+		}
+		out = out[:0]
+	}
+	// string terminator(ST)
+	out = append(out, 0x1b, 0x5c)
+	e.outScratch = out[:0]
+	if _, err := e.w.Write(out); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readRow reads a row that must exist.
+func readRow(src RowSource, dst []byte) error {
+	if err := src.ReadRow(dst); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// rowMapper maps rows of a RowSource to palette indices.
+type rowMapper struct {
+	palette color.Palette
+	// exact maps colors to indices when the image has few enough colors to
+	// keep them all, as palettedFromNRGBA does.
+	exact map[uint32]uint8
+	// transparent is the index of fully transparent pixels.
+	transparent uint8
+	lut         []uint8
+	dither      *ditherer
+	// scratch holds a full dithered row when the output is cropped.
+	scratch []uint8
+}
+
+// newRowMapper reads the first height rows of src and builds a palette of
+// at most maxColors colors plus a transparent entry.
+func newRowMapper(src RowSource, row []byte, height, maxColors int, dither bool) (*rowMapper, error) {
+	width := len(row) / 4
+	step := sampleStep(width, height)
+	sample := image.NewNRGBA(image.Rect(0, 0, width/step, height/step))
+	if sample.Rect.Empty() {
+		sample = image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	}
+	exact := make(map[uint32]uint8, maxColors)
+	palette := make(color.Palette, 1, maxColors+1)
+	palette[0] = color.NRGBA{}
+	if dither {
+		exact = nil
+	}
+	hasTransparent := false
+	for y := 0; y < height; y++ {
+		if err := readRow(src, row); err != nil {
+			return nil, err
+		}
+		if sy := y / step; y%step == 0 && sy < sample.Rect.Dy() {
+			do := sample.PixOffset(0, sy)
+			for sx := 0; sx < sample.Rect.Dx(); sx++ {
+				copy(sample.Pix[do:do+4], row[sx*step*4:])
+				do += 4
+			}
+		}
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+4 : x*4+4]
+			if p[3] == 0 {
+				hasTransparent = true
+				continue
+			}
+			if exact == nil {
+				continue
+			}
+			key := uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+			if _, ok := exact[key]; ok {
+				continue
+			}
+			if len(palette) > maxColors {
+				exact = nil
+				continue
+			}
+			exact[key] = uint8(len(palette))
+			palette = append(palette, color.NRGBA{p[0], p[1], p[2], p[3]})
+		}
+	}
+
+	m := &rowMapper{}
+	if exact != nil {
+		m.exact = exact
+		m.palette = palette
+		return m, nil
+	}
+	// make adaptive palette using median cut alogrithm
+	m.palette = median.Quantizer(0).Quantize(make(color.Palette, 0, maxColors), sample)
+	m.lut = newPaletteLUT(m.palette)
+	if dither {
+		m.dither = newDitherer(m.palette, m.lut, width)
+		m.scratch = make([]uint8, width)
+	}
+	if hasTransparent {
+		m.transparent = uint8(len(m.palette))
+		m.palette = append(m.palette, color.NRGBA{})
+	}
+	return m, nil
+}
+
+// mapRow maps the pixels of row to palette indices in dst. Pixels beyond
+// len(dst) are dropped after dithering.
+func (m *rowMapper) mapRow(dst []uint8, row []byte) {
+	width := len(row) / 4
+	if m.exact != nil {
+		for x := range dst {
+			p := row[x*4 : x*4+4 : x*4+4]
+			if p[3] == 0 {
+				dst[x] = 0
+				continue
+			}
+			dst[x] = m.exact[uint32(p[0])<<24|uint32(p[1])<<16|uint32(p[2])<<8|uint32(p[3])]
+		}
+		return
+	}
+	if m.dither != nil {
+		if len(dst) < width {
+			m.dither.row(m.scratch, row)
+			copy(dst, m.scratch)
+		} else {
+			m.dither.row(dst, row)
+		}
+	} else {
+		for x := range dst {
+			dst[x] = m.lut[lutIndex(row[x*4], row[x*4+1], row[x*4+2])]
+		}
+	}
+	for x := range dst {
+		if row[x*4+3] == 0 {
+			dst[x] = m.transparent
+		}
+	}
+}
+
+// imageRows is a RowSource reading from an image.Image.
+type imageRows struct {
+	img image.Image
+	y   int
+	tmp *image.NRGBA
+}
+
+// ImageRowSource returns a RowSource reading the rows of img.
+func ImageRowSource(img image.Image) RowSource {
+	return &imageRows{img: img}
+}
+
+func (r *imageRows) Size() (int, int) {
+	b := r.img.Bounds()
+	return b.Dx(), b.Dy()
+}
+
+func (r *imageRows) ReadRow(dst []byte) error {
+	b := r.img.Bounds()
+	if r.y >= b.Dy() {
+		return io.EOF
+	}
+	y := b.Min.Y + r.y
+	r.y++
+	if p, ok := r.img.(*image.NRGBA); ok {
+		copy(dst, p.Pix[p.PixOffset(b.Min.X, y):])
+		return nil
+	}
+	if r.tmp == nil {
+		r.tmp = image.NewNRGBA(image.Rect(0, 0, b.Dx(), 1))
+	}
+	draw.Draw(r.tmp, r.tmp.Rect, r.img, image.Pt(b.Min.X, y), draw.Src)
+	copy(dst, r.tmp.Pix)
+	return nil
+}
+
+func (r *imageRows) Rewind() error {
+	r.y = 0
+	return nil
+}
@@ -0,0 +1,79 @@
+package sixel
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeRowsMatchesEncode(t *testing.T) {
+	paletted := benchmarkPalettedImage(50, 37)
+	few := image.NewNRGBA(paletted.Bounds())
+	for y := 0; y < 37; y++ {
+		for x := 0; x < 50; x++ {
+			few.Set(x, y, paletted.At(x, y))
+		}
+	}
+	for _, tt := range []struct {
+		name   string
+		img    image.Image
+		dither bool
+	}{
+		{"exact", few, false},
+		{"quantize", benchmarkGradientImage(123, 45), false},
+		{"dither", benchmarkGradientImage(123, 45), true},
+	} {
+		var want, got bytes.Buffer
+		enc := NewEncoder(&want)
+		enc.Dither = tt.dither
+		enc.Colors = 16
+		if err := enc.Encode(tt.img); err != nil {
+			t.Fatalf("%s: Encode returned error: %v", tt.name, err)
+		}
+		enc = NewEncoder(&got)
+		enc.Dither = tt.dither
+		enc.Colors = 16
+		if err := enc.EncodeRows(ImageRowSource(tt.img)); err != nil {
+			t.Fatalf("%s: EncodeRows returned error: %v", tt.name, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("%s: EncodeRows output differs from Encode", tt.name)
+		}
+	}
+}
+
+func TestEncodeRowsTransparent(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 3, 1))
+	img.Set(1, 0, color.NRGBA64{0xFFFF, 0, 0, 0xFFFF})
+	img.Set(2, 0, color.NRGBA64{0, 0xFFFF, 0, 0xFFFF})
+
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+	enc.Transparent = true
+	enc.Colors = 3
+	if err := enc.EncodeRows(ImageRowSource(img)); err != nil {
+		t.Fatalf("EncodeRows returned error: %v", err)
+	}
+	var decoded image.Image
+	if err := NewDecoder(&out).Decode(&decoded); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if _, _, _, a := decoded.At(0, 0).RGBA(); a != 0 {
+		t.Fatalf("transparent pixel was painted")
+	}
+	if _, _, _, a := decoded.At(1, 0).RGBA(); a == 0 {
+		t.Fatalf("opaque pixel was not painted")
+	}
+}
+
+type shortRows struct{ RowSource }
+
+func (shortRows) Size() (int, int) { return 4, 8 }
+
+func TestEncodeRowsShortSource(t *testing.T) {
+	src := shortRows{ImageRowSource(image.NewNRGBA(image.Rect(0, 0, 4, 2)))}
+	if err := NewEncoder(&bytes.Buffer{}).EncodeRows(src); err == nil {
+		t.Fatalf("EncodeRows accepted a source with missing rows")
+	}
+}
@@ -0,0 +1,280 @@
+package sixel
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// SanitizeMode selects what SanitizeWriter does with sixel images.
+type SanitizeMode int
+
+const (
+	// SanitizeStrip removes every sixel image.
+	SanitizeStrip SanitizeMode = iota
+	// SanitizeLimit passes images that decode without errors within the
+	// limits of the SanitizeWriter and removes the others.
+	SanitizeLimit
+	// SanitizePlaceholder replaces every sixel image with the placeholder
+	// text.
+	SanitizePlaceholder
+)
+
+// DefaultPlaceholder is written for images by SanitizePlaceholder when
+// SanitizeWriter.Placeholder is empty.
+const DefaultPlaceholder = "[sixel image]"
+
+// DefaultMaxImageBytes is the largest image SanitizeLimit passes when
+// SanitizeWriter.MaxBytes is zero.
+const DefaultMaxImageBytes = 1 << 23
+
+// maxDCSParams bounds the parameter bytes held for a DCS introducer. Longer
+// parameters are treated as an image that cannot pass, so that the held
+// bytes cannot grow without limit.
+const maxDCSParams = 256
+
+// SanitizeWriter filters sixel images out of a stream of terminal output
+// written to it, such as the output of untrusted programs, and writes the
+// rest unchanged to the underlying writer. Escape sequences and DCS strings
+// other than sixel images pass through. Like a terminal, it ignores other
+// C0 controls inside an escape sequence or DCS introducer, and treats an
+// image as aborted by CAN, SUB or another escape sequence; aborted images
+// are always removed, as are DCS strings with overlong parameters.
+type SanitizeWriter struct {
+	w io.Writer
+
+	// Mode selects what happens to images.
+	Mode SanitizeMode
+
+	// Placeholder is the text written instead of each image by
+	// SanitizePlaceholder. If empty, DefaultPlaceholder is used.
+	Placeholder string
+
+	// C1, if true, also recognizes the 8-bit DCS and ST controls (0x90
+	// and 0x9C). Leave it false for UTF-8 streams, where those bytes are
+	// part of characters.
+	C1 bool
+
+	// MaxWidth, MaxHeight, MaxPixels and MaxColorRegisters are the limits
+	// images must keep for SanitizeLimit, as for Decoder. MaxBytes bounds
+	// the size of an image, which is held back until it is complete. Zero
+	// values select the defaults.
+	MaxWidth          int
+	MaxHeight         int
+	MaxPixels         int
+	MaxColorRegisters int
+	MaxBytes          int
+
+	state int
+	// hold keeps the bytes of a sequence not yet passed on: an ESC, a DCS
+	// introducer or, for SanitizeLimit, the image being read
+	hold []byte
+	// reject is set when the image being read cannot pass
+	reject bool
+	out    []byte
+}
+
+// states of SanitizeWriter
+const (
+	sanitizeGround   = iota
+	sanitizeEsc      // after ESC
+	sanitizeDCS      // in the parameters of a DCS
+	sanitizeImage    // in sixel data
+	sanitizeImageEsc // after ESC in sixel data
+)
+
+// NewSanitizeWriter returns a SanitizeWriter writing to w in the given
+// mode.
+func NewSanitizeWriter(w io.Writer, mode SanitizeMode) *SanitizeWriter {
+	return &SanitizeWriter{w: w, Mode: mode}
+}
+
+// Write filters p and writes the result. Bytes of sequences that may
+// start an image are held back until it is known whether they do, so the
+// output lags behind the input by at most one such sequence.
+func (s *SanitizeWriter) Write(p []byte) (int, error) {
+	out := s.out[:0]
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch s.state {
+		case sanitizeGround:
+			// copy text up to the next control of interest
+			j := i
+			for j < len(p) && p[j] != 0x1b && !(s.C1 && p[j] == 0x90) {
+				j++
+			}
+			out = append(out, p[i:j]...)
+			if j == len(p) {
+				i = j
+				continue
+			}
+			i = j
+			s.hold = append(s.hold[:0], p[i])
+			if p[i] == 0x90 {
+				s.state = sanitizeDCS
+			} else {
+				s.state = sanitizeEsc
+			}
+		case sanitizeEsc:
+			if c == 'P' {
+				s.hold = append(s.hold, c)
+				s.state = sanitizeDCS
+				continue
+			}
+			if ignoredC0(c) {
+				// executed by the terminal without leaving the sequence
+				s.hold = append(s.hold, c)
+				continue
+			}
+			out = append(out, s.hold...)
+			s.state = sanitizeGround
+			i--
+		case sanitizeDCS:
+			switch {
+			case c == 'q':
+				s.hold = append(s.hold, c)
+				s.state = sanitizeImage
+				s.reject = false
+			case c >= 0x30 && c <= 0x3f || ignoredC0(c):
+				// parameter bytes, and controls the terminal ignores
+				if len(s.hold) >= maxDCSParams {
+					// terminals saturate parameters, so this may still
+					// start an image
+					s.hold = s.hold[:0]
+					s.state = sanitizeImage
+					s.reject = true
+					continue
+				}
+				s.hold = append(s.hold, c)
+			default:
+				// not a sixel image
+				out = append(out, s.hold...)
+				s.state = sanitizeGround
+				i--
+			}
+		case sanitizeImage:
+			j := i
+			for j < len(p) && p[j] >= 0x20 && p[j] < 0x7f {
+				j++
+			}
+			s.keep(p[i:j])
+			if j == len(p) {
+				i = j
+				continue
+			}
+			i = j
+			switch c = p[i]; {
+			case c == 0x1b:
+				s.state = sanitizeImageEsc
+			case s.C1 && c == 0x9c:
+				s.keep(p[i : i+1])
+				out = s.end(out, true)
+			case c == 0x18 || c == 0x1a:
+				// CAN and SUB abort the image and are passed on
+				out = s.end(out, false)
+				i--
+			case c >= 0x80:
+				// not sixel data; the decoder may read it differently
+				// than the terminal does
+				s.reject = true
+			default:
+				s.keep(p[i : i+1])
+			}
+		case sanitizeImageEsc:
+			if c == '\\' {
+				s.keep([]byte{0x1b, c})
+				out = s.end(out, true)
+				continue
+			}
+			// another escape sequence aborts the image and starts
+			out = s.end(out, false)
+			s.hold = append(s.hold[:0], 0x1b)
+			s.state = sanitizeEsc
+			i--
+		}
+	}
+	s.out = out[:0]
+	if len(out) > 0 {
+		if _, err := s.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// ignoredC0 reports whether c is a C0 control that does not end an escape
+// sequence or DCS introducer: any but CAN, SUB and ESC, and DEL.
+func ignoredC0(c byte) bool {
+	return c < 0x20 && c != 0x18 && c != 0x1a && c != 0x1b || c == 0x7f
+}
+
+// keep adds image data to the held image if it may pass.
+func (s *SanitizeWriter) keep(p []byte) {
+	if s.Mode != SanitizeLimit || s.reject {
+		return
+	}
+	max := s.MaxBytes
+	if max <= 0 {
+		max = DefaultMaxImageBytes
+	}
+	if len(s.hold)+len(p) > max {
+		s.reject = true
+		s.hold = s.hold[:0]
+		return
+	}
+	s.hold = append(s.hold, p...)
+}
+
+// end finishes the image being read, complete if it had a string
+// terminator, and appends what replaces it to out.
+func (s *SanitizeWriter) end(out []byte, complete bool) []byte {
+	switch s.Mode {
+	case SanitizeLimit:
+		if complete && !s.reject && s.valid(s.hold) {
+			out = append(out, s.hold...)
+		}
+	case SanitizePlaceholder:
+		if s.Placeholder != "" {
+			out = append(out, s.Placeholder...)
+		} else {
+			out = append(out, DefaultPlaceholder...)
+		}
+	}
+	s.hold = s.hold[:0]
+	s.state = sanitizeGround
+	return out
+}
+
+// valid reports whether the image in data decodes without errors within
+// the limits.
+func (s *SanitizeWriter) valid(data []byte) bool {
+	l := (&Decoder{
+		MaxWidth:          s.MaxWidth,
+		MaxHeight:         s.MaxHeight,
+		MaxPixels:         s.MaxPixels,
+		MaxColorRegisters: s.MaxColorRegisters,
+	}).limits()
+	d := decoder{br: bufio.NewReader(bytes.NewReader(data)), measure: true, limits: l}
+	found, err := d.readIntroducer()
+	if err != nil || !found {
+		return false
+	}
+	return d.readData() == nil
+}
+
+// Flush writes the bytes held back for a sequence that has not turned out
+// to be an image yet. An image being read is left alone, so that the rest
+// of it is still filtered.
+func (s *SanitizeWriter) Flush() error {
+	if s.state != sanitizeEsc && s.state != sanitizeDCS {
+		return nil
+	}
+	hold := s.hold
+	s.hold = s.hold[:0]
+	s.state = sanitizeGround
+	if len(hold) == 0 {
+		return nil
+	}
+	_, err := s.w.Write(hold)
+	return err
+}
@@ -0,0 +1,148 @@
+package sixel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// sanitize writes input to a SanitizeWriter configured by setup, in one
+// call and byte by byte, and returns the output, which must be the same.
+func sanitize(t *testing.T, input string, setup func(*SanitizeWriter)) string {
+	t.Helper()
+	var whole, bytewise bytes.Buffer
+	s := NewSanitizeWriter(&whole, SanitizeStrip)
+	setup(s)
+	if n, err := s.Write([]byte(input)); err != nil || n != len(input) {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	s.Flush()
+	s = NewSanitizeWriter(&bytewise, SanitizeStrip)
+	setup(s)
+	for i := 0; i < len(input); i++ {
+		s.Write([]byte{input[i]})
+	}
+	s.Flush()
+	if whole.String() != bytewise.String() {
+		t.Fatalf("output differs when written byte by byte: %q and %q", whole.String(), bytewise.String())
+	}
+	return whole.String()
+}
+
+func TestSanitizeWriter(t *testing.T) {
+	image := "\x1bP0;1q\"1;1;2;6#1;2;100;0;0~~\x1b\\"
+	big := "\x1bPq#1;2;100;0;0!20000~\x1b\\"
+	invalid := "\x1bPq#300~\x1b\\"
+	text := "héllo \x1b[1mworld\x1b[0m \x1bP$qm\x1b\\ \x1b\x1b[K"
+	// terminals ignore C0 controls in the introducer and still draw these
+	c0 := "|\x1bP\nq#1;2;100;0;0~~~~\x1b\\|\x1bP0;\r1q#1;2;100;0;0~~\x1b\\|\x1b\nPq#1;2;100;0;0~\x1b\\"
+	input := text + image + "|" + big + "|" + invalid + c0 + "|\x1bPq~\x1b[2J" + text
+
+	for _, tt := range []struct {
+		name  string
+		setup func(*SanitizeWriter)
+		want  string
+	}{
+		{
+			name:  "strip",
+			setup: func(s *SanitizeWriter) {},
+			want:  text + "||||||\x1b[2J" + text,
+		},
+		{
+			name:  "placeholder",
+			setup: func(s *SanitizeWriter) { s.Mode = SanitizePlaceholder },
+			want:  text + strings.Repeat(DefaultPlaceholder+"|", 6) + DefaultPlaceholder + "\x1b[2J" + text,
+		},
+		{
+			name: "custom placeholder",
+			setup: func(s *SanitizeWriter) {
+				s.Mode = SanitizePlaceholder
+				s.Placeholder = "#"
+			},
+			want: text + "#|#|#|#|#|#|#\x1b[2J" + text,
+		},
+		{
+			name:  "limit",
+			setup: func(s *SanitizeWriter) { s.Mode = SanitizeLimit },
+			want:  text + image + "||||||\x1b[2J" + text,
+		},
+		{
+			name: "limit bytes",
+			setup: func(s *SanitizeWriter) {
+				s.Mode = SanitizeLimit
+				s.MaxBytes = 10
+			},
+			want: text + "||||||\x1b[2J" + text,
+		},
+	} {
+		if got := sanitize(t, input, tt.setup); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeWriterC1(t *testing.T) {
+	// 0x90 and 0x9c are UTF-8 continuation bytes unless C1 is set
+	input := "Аа\x90q~\x9cМ"
+	if got := sanitize(t, input, func(s *SanitizeWriter) {}); got != input {
+		t.Errorf("got %q, want the input unchanged", got)
+	}
+	want := "АаМ"
+	if got := sanitize(t, input, func(s *SanitizeWriter) { s.C1 = true }); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// without C1 a 0x9c does not end an image, so it cannot pass
+	input = "\x1bPq#1;2;0;0;0~\x9c~\x1b\\"
+	if got := sanitize(t, input, func(s *SanitizeWriter) { s.Mode = SanitizeLimit }); got != "" {
+		t.Errorf("got %q, want the image removed", got)
+	}
+}
+
+func TestSanitizeWriterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewSanitizeWriter(&buf, SanitizeStrip)
+	s.Write([]byte("abc\x1bP1;2"))
+	if got := buf.String(); got != "abc" {
+		t.Fatalf("got %q before Flush", got)
+	}
+	s.Flush()
+	if got := buf.String(); got != "abc\x1bP1;2" {
+		t.Fatalf("got %q after Flush", got)
+	}
+}
+
+func TestSanitizeWriterLargeRaster(t *testing.T) {
+	// checking images decodes them without allocating their pixels
+	input := strings.Repeat("\x1bPq\"1;1;5000;5000#0~\x1b\\", 5)
+	var buf bytes.Buffer
+	n := allocated(func() {
+		s := NewSanitizeWriter(&buf, SanitizeLimit)
+		s.Write([]byte(input))
+		s.Flush()
+	})
+	if buf.String() != input {
+		t.Fatalf("got %q, want the images passed", buf.String())
+	}
+	if n > 1<<20 {
+		t.Fatalf("SanitizeWriter allocated %d bytes", n)
+	}
+}
+
+func TestSanitizeWriterLongParameters(t *testing.T) {
+	// parameters are not held beyond a bound, and the image is removed
+	input := "a\x1bP" + strings.Repeat("1", 1<<23) + "q#1;2;100;0;0~\x1b\\b"
+	for _, mode := range []SanitizeMode{SanitizeStrip, SanitizeLimit} {
+		var buf bytes.Buffer
+		n := allocated(func() {
+			s := NewSanitizeWriter(&buf, mode)
+			s.Write([]byte(input))
+			s.Flush()
+		})
+		if got := buf.String(); got != "ab" {
+			t.Fatalf("mode %d: got %.20q, want %q", mode, got, "ab")
+		}
+		if n > 1<<20 {
+			t.Fatalf("mode %d: SanitizeWriter allocated %d bytes", mode, n)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package sixel
+
+import (
+	"image"
+	"image/draw"
+)
+
+// scaler holds the state of reduced resolution decoding: sums of the
+// painted pixels of the output row being reduced and the output so far.
+type scaler struct {
+	// acc holds red, green, blue and count per output column of row accY
+	acc  []uint32
+	accY int
+	// out holds the reduced rows finished so far
+	out *image.NRGBA
+}
+
+// flushBand reduces the band held in the canvas at d.y into the output
+// and clears it.
+func (d *decoder) flushBand() {
+	s := d.scale
+	w := min(d.w, d.dw)
+	if cols := (w + s - 1) / s; len(d.acc) < cols*4 {
+		d.acc = append(d.acc, make([]uint32, cols*4-len(d.acc))...)
+	}
+	for p := 0; p < 6 && p < d.h; p++ {
+		row := d.pix[p*d.stride : p*d.stride+w*4]
+		painted := false
+		for x := 0; x < w; x++ {
+			if row[x*4+3] != 0 {
+				painted = true
+				break
+			}
+		}
+		if !painted {
+			continue
+		}
+		if y := (d.y + p) / s; y != d.accY {
+			d.finishRow()
+			d.accY = y
+		}
+		for x := 0; x < w; x++ {
+			px := row[x*4 : x*4+4 : x*4+4]
+			if px[3] == 0 {
+				continue
+			}
+			a := d.acc[x/s*4 : x/s*4+4 : x/s*4+4]
+			a[0] += uint32(px[0])
+			a[1] += uint32(px[1])
+			a[2] += uint32(px[2])
+			a[3]++
+			px[0], px[1], px[2], px[3] = 0, 0, 0, 0
+		}
+	}
+}
+
+// finishRow writes the averages of output row d.accY to the output.
+func (d *decoder) finishRow() {
+	if d.accY < 0 {
+		return
+	}
+	cols := len(d.acc) / 4
+	d.growOut(cols, d.accY+1)
+	o := d.out.PixOffset(0, d.accY)
+	dst := d.out.Pix[o : o+cols*4]
+	area := uint32(d.scale * d.scale)
+	for x := 0; x < cols; x++ {
+		a := d.acc[x*4 : x*4+4 : x*4+4]
+		if n := a[3]; n > 0 {
+			dst[x*4] = uint8((a[0] + n/2) / n)
+			dst[x*4+1] = uint8((a[1] + n/2) / n)
+			dst[x*4+2] = uint8((a[2] + n/2) / n)
+			dst[x*4+3] = uint8((n*0xFF + area/2) / area)
+		}
+		a[0], a[1], a[2], a[3] = 0, 0, 0, 0
+	}
+	d.accY = -1
+}
+
+// growOut makes the output at least w x h pixels, doubling it as needed.
+func (d *decoder) growOut(w, h int) {
+	var b image.Rectangle
+	if d.out != nil {
+		b = d.out.Rect
+		if w <= b.Dx() && h <= b.Dy() {
+			return
+		}
+	}
+	nw, nh := b.Dx(), b.Dy()
+	if w > nw {
+		nw = max(w, 2*nw, 32)
+	}
+	if h > nh {
+		nh = max(h, 2*nh, 32)
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, nw, nh))
+	if d.out != nil {
+		draw.Draw(out, b, d.out, image.Point{}, draw.Src)
+	}
+	d.out = out
+}
+
+// scaledImage returns the reduced image.
+func (d *decoder) scaledImage() image.Image {
+	d.flushBand()
+	d.finishRow()
+	s := d.scale
+	ow, oh := d.size()
+	img := image.NewNRGBA(image.Rect(0, 0, (ow+s-1)/s, (oh+s-1)/s))
+	if d.out != nil {
+		draw.Draw(img, img.Rect, d.out, image.Point{}, draw.Src)
+	}
+
+	// Blocks cut off by the right and bottom edges cover fewer pixels, so
+	// their opacity is relative to that smaller area.
+	if r := ow % s; r != 0 {
+		for y := 0; y < img.Rect.Dy(); y++ {
+			scaleAlpha(img, img.Rect.Dx()-1, y, s, r)
+		}
+	}
+	if r := oh % s; r != 0 {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			scaleAlpha(img, x, img.Rect.Dy()-1, s, r)
+		}
+	}
+
+	// Averaging commutes with drawing over a uniform color, so filling
+	// the reduced image gives the same result as filling first.
+	if fill := d.fillRect(); !fill.Empty() {
+		fill = image.Rect(0, 0, (fill.Max.X+s-1)/s, (fill.Max.Y+s-1)/s).Intersect(img.Rect)
+		bg := image.NewNRGBA(fill)
+		draw.Draw(bg, fill, &image.Uniform{d.background()}, image.Point{}, draw.Src)
+		draw.Draw(bg, fill, img, fill.Min, draw.Over)
+		draw.Draw(img, fill, bg, fill.Min, draw.Src)
+	}
+	return img
+}
+
+// scaleAlpha scales the alpha of the pixel at (x, y) by num/den.
+func scaleAlpha(img *image.NRGBA, x, y, num, den int) {
+	i := img.PixOffset(x, y) + 3
+	img.Pix[i] = uint8(min(int(img.Pix[i])*num/den, 0xFF))
+}
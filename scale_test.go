@@ -0,0 +1,114 @@
+package sixel
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// boxFilter reduces img by s the way Decoder.Scale should.
+func boxFilter(img image.Image, s int) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, (b.Dx()+s-1)/s, (b.Dy()+s-1)/s))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			var r, g, bl, n, area int
+			for sy := y * s; sy < min(y*s+s, b.Dy()); sy++ {
+				for sx := x * s; sx < min(x*s+s, b.Dx()); sx++ {
+					area++
+					c := color.NRGBAModel.Convert(img.At(sx, sy)).(color.NRGBA)
+					if c.A == 0 {
+						continue
+					}
+					r, g, bl, n = r+int(c.R), g+int(c.G), bl+int(c.B), n+1
+				}
+			}
+			if n > 0 {
+				out.SetNRGBA(x, y, color.NRGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((bl + n/2) / n), uint8((n*255 + area/2) / area)})
+			}
+		}
+	}
+	return out
+}
+
+func TestDecodeScale(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 37, 29))
+	for y := 0; y < 29; y++ {
+		for x := 0; x < 37; x++ {
+			if (x-18)*(x-18)+(y-14)*(y-14) > 150 {
+				continue
+			}
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 8), 0x80, 0xFF})
+		}
+	}
+	for _, transparent := range []bool{true, false} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Transparent = transparent
+		if err := enc.Encode(src); err != nil {
+			t.Fatalf("Encode returned error: %v", err)
+		}
+		full := decodeString(t, buf.String())
+		for _, s := range []int{2, 4, 8} {
+			dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+			dec.Scale = s
+			dec.Paletted = true
+			var img image.Image
+			if err := dec.Decode(&img); err != nil {
+				t.Fatalf("Decode returned error: %v", err)
+			}
+			got, ok := img.(*image.NRGBA)
+			if !ok {
+				t.Fatalf("Decode returned %T, want *image.NRGBA", img)
+			}
+			want := boxFilter(full, s)
+			if got.Rect != want.Rect {
+				t.Fatalf("scale %d: got bounds %v, want %v", s, got.Rect, want.Rect)
+			}
+			for i := range got.Pix {
+				if d := int(got.Pix[i]) - int(want.Pix[i]); d < -2 || d > 2 {
+					t.Fatalf("transparent %v, scale %d: pixel %d: got %v, want %v",
+						transparent, s, i/4, got.Pix[i/4*4:i/4*4+4], want.Pix[i/4*4:i/4*4+4])
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeScaleMemory(t *testing.T) {
+	// a tall image is reduced band by band without a full size canvas
+	input := "\x1bP0;1q#1;2;100;0;0" + strings.Repeat("!1000~-", 1000) + "\x1b\\"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	dec := NewDecoder(strings.NewReader(input))
+	dec.Scale = 8
+	var img image.Image
+	if err := dec.Decode(&img); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if got := img.Bounds(); got != image.Rect(0, 0, 125, 750) {
+		t.Fatalf("got bounds %v, want 125x750", got)
+	}
+	if got := img.(*image.NRGBA).NRGBAAt(60, 700); got != (color.NRGBA{0xFF, 0, 0, 0xFF}) {
+		t.Fatalf("got %v, want red", got)
+	}
+	// the full canvas would take 24MB
+	if n := after.TotalAlloc - before.TotalAlloc; n > 4<<20 {
+		t.Fatalf("Decode allocated %d bytes", n)
+	}
+}
+
+func TestGrowScaleBand(t *testing.T) {
+	// near the pixel limit the canvas still holds a single band
+	d := decoder{scale: 8, bpp: 4, limits: limits{DefaultMaxWidth, DefaultMaxHeight, 1 << 16, 256}}
+	d.grow(6000, 6)
+	d.dw, d.dh = 6000, 9
+	d.grow(6001, 6)
+	if d.w != 6001 || d.h != 6 {
+		t.Fatalf("canvas is %dx%d, want 6001x6", d.w, d.h)
+	}
+}
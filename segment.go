@@ -0,0 +1,69 @@
+package sixel
+
+import (
+	"image"
+	"io"
+)
+
+// Segment is a sixel image read from a stream along with the text that
+// preceded it.
+type Segment struct {
+	// Offset is the byte offset of the DCS introducer of the image in the
+	// stream.
+	Offset int64
+
+	// Text holds the bytes between the previous image, or the start of the
+	// stream, and this one: plain text, escape sequences and DCS strings
+	// other than sixel.
+	Text []byte
+
+	// Image is the decoded image. It is nil for the last segment of a
+	// stream, which only holds the text after the last image.
+	Image image.Image
+
+	// Header holds the parameters of the image.
+	Header Header
+}
+
+// Next reads the next image in the stream together with the text before
+// it. Unlike Decode, it accepts escape sequences and other DCS strings
+// between images, returning them as text. When no image is left it returns
+// a Segment with a nil Image holding any remaining text, and io.EOF after
+// that.
+func (e *Decoder) Next() (*Segment, error) {
+	if e.done {
+		return nil, io.EOF
+	}
+	var s Segment
+	off, err := e.decode(&s.Image, &s.Text)
+	if err != nil {
+		return nil, err
+	}
+	if s.Image == nil {
+		e.done = true
+		if len(s.Text) == 0 {
+			return nil, io.EOF
+		}
+		return &s, nil
+	}
+	s.Offset = off
+	s.Header = e.header
+	return &s, nil
+}
+
+// DecodeAll reads every sixel image in r, along with the text around them,
+// as Next does.
+func DecodeAll(r io.Reader) ([]*Segment, error) {
+	var segments []*Segment
+	d := NewDecoder(r)
+	for {
+		s, err := d.Next()
+		if err == io.EOF {
+			return segments, nil
+		}
+		if err != nil {
+			return segments, err
+		}
+		segments = append(segments, s)
+	}
+}
@@ -0,0 +1,79 @@
+package sixel
+
+import (
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeAll(t *testing.T) {
+	first := "\x1bPq\"1;1;2;6#1;2;100;0;0~~\x1b\\"
+	second := "\x90q#1;2;0;0;100~\x9c"
+	input := "$ cat a.six\r\n\x1b[1m" + first + "\x1bP$qm\x1b\\" + second + "\r\n$ "
+	segments, err := DecodeAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("DecodeAll returned error: %v", err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	for i, want := range []struct {
+		offset int
+		text   string
+		bounds image.Rectangle
+	}{
+		{strings.Index(input, first), "$ cat a.six\r\n\x1b[1m", image.Rect(0, 0, 2, 6)},
+		{strings.Index(input, second), "\x1bP$qm\x1b\\", image.Rect(0, 0, 1, 6)},
+	} {
+		s := segments[i]
+		if s.Offset != int64(want.offset) {
+			t.Errorf("segment %d: got offset %d, want %d", i, s.Offset, want.offset)
+		}
+		if string(s.Text) != want.text {
+			t.Errorf("segment %d: got text %q, want %q", i, s.Text, want.text)
+		}
+		if s.Image == nil || s.Image.Bounds() != want.bounds {
+			t.Errorf("segment %d: got image %v, want bounds %v", i, s.Image, want.bounds)
+		}
+	}
+	if s := segments[2]; s.Image != nil || string(s.Text) != "\r\n$ " {
+		t.Errorf("trailing segment: got image %v and text %q", s.Image, s.Text)
+	}
+	if got := segments[0].Header; !got.RasterAttributes || got.Ph != 2 {
+		t.Errorf("got header %+v", got)
+	}
+}
+
+func TestDecodeAllUTF8(t *testing.T) {
+	// 0x90 continues the UTF-8 characters Ð (C3 90) and ᐐ (E1 90 90), but
+	// starts an image after a whole character
+	text := "Ðq~ ᐐq~ "
+	image := "\x90q#1;2;0;0;100~\x9c"
+	segments, err := DecodeAll(strings.NewReader(text + "Ð" + image))
+	if err != nil {
+		t.Fatalf("DecodeAll returned error: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Image == nil || string(segments[0].Text) != text+"Ð" {
+		t.Fatalf("got %d segments, want one image after %q", len(segments), text+"Ð")
+	}
+	segments, err = DecodeAll(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("DecodeAll returned error: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Image != nil || string(segments[0].Text) != text {
+		t.Fatalf("got %d segments, want only the text %q", len(segments), text)
+	}
+}
+
+func TestNextEOF(t *testing.T) {
+	d := NewDecoder(strings.NewReader("\x1bPq~\x1b\\"))
+	if _, err := d.Next(); err != nil {
+		t.Fatalf("Next returned error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.Next(); err != io.EOF {
+			t.Fatalf("Next returned %v, want io.EOF", err)
+		}
+	}
+}
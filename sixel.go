@@ -1,9 +1,7 @@
 package sixel
 
 import (
-	"bufio"
 	"errors"
-	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -36,11 +34,38 @@ type Encoder struct {
 	// painted in the terminal's background color (P2=0).
 	Transparent bool
 
-	outScratch    []byte
-	bitsetScratch []byte
-	seenScratch   []uint16
-	opaqueScratch []byte
-	seenGen       uint16
+	// Profile, if set, limits the output to what the terminal supports:
+	// Colors is clamped to its color registers and the drawn area to its
+	// maximum geometry.
+	Profile *Profile
+
+	// Weights, if set, biases palette selection toward pixels with a higher
+	// weight when the image has to be quantized. It holds one non-negative
+	// weight per pixel of the image bounds in row-major order; EdgeWeights
+	// derives one from the image itself.
+	Weights []float32
+
+	// WeightMask, if set and Weights is nil, supplies the weights from the
+	// alpha channel of an image covering the bounds of the encoded image.
+	WeightMask image.Image
+
+	// TileWidth and TileHeight, if positive, split images larger than them
+	// into several sixel sequences placed next to and below each other, for
+	// terminals that limit the size of one sequence. All tiles share one
+	// palette. Profile geometry limits the tile size instead of the image
+	// size when tiling. Positioning the tiles requires CellWidth and
+	// CellHeight.
+	TileWidth  int
+	TileHeight int
+
+	// CellWidth and CellHeight are the size of a terminal character cell in
+	// pixels. Tiles are rounded down to whole cells, and are at least one
+	// cell.
+	CellWidth  int
+	CellHeight int
+
+	outScratch []byte
+	bands      sixelBands
 }
 
 // NewEncoder return new instance of Encoder
@@ -53,14 +78,21 @@ const (
 	specialChCr = byte(0x64)
 )
 
-// Encode do encoding
-func (e *Encoder) Encode(img image.Image) error {
+// colors returns the number of color registers to use, including the one
+// reserved for the transparent key color.
+func (e *Encoder) colors() int {
 	nc := e.Colors // (>= 2, one slot is reserved for the transparent key color)
 	if nc < 2 {
 		nc = 256
 	} else if nc > 256 {
 		nc = 256
 	}
+	return e.Profile.clampColors(nc)
+}
+
+// Encode do encoding
+func (e *Encoder) Encode(img image.Image) error {
+	nc := e.colors()
 
 	width, height := img.Bounds().Dx(), img.Bounds().Dy()
 	if width == 0 || height == 0 {
@@ -72,6 +104,9 @@ func (e *Encoder) Encode(img image.Image) error {
 	if e.Height > 0 {
 		height = e.Height
 	}
+	if e.TileWidth <= 0 && e.TileHeight <= 0 {
+		width, height = e.Profile.clampSize(width, height)
+	}
 	srcBounds := img.Bounds()
 	srcWidth := srcBounds.Dx()
 	srcHeight := srcBounds.Dy()
@@ -95,9 +130,13 @@ func (e *Encoder) Encode(img image.Image) error {
 		paletted = nil
 	}
 	if paletted == nil {
+		weights, err := e.weights(img.Bounds())
+		if err != nil {
+			return err
+		}
 		rgba := toRGBA(img)
 		// make adaptive palette using median cut alogrithm
-		palette := samplePalette(rgba, nc-1)
+		palette := samplePalette(rgba, nc-1, weights)
 		lut := newPaletteLUT(palette)
 		paletted = image.NewPaletted(rgba.Bounds(), palette)
 		if e.Dither {
@@ -128,16 +167,47 @@ func (e *Encoder) Encode(img image.Image) error {
 		}
 	}
 
-	// Color registers are not shifted: slot 0 is an ordinary register per
-	// DEC STD 070; transparency comes from leaving pixels unencoded.
-	paletteSize := len(paletted.Palette)
+	src := image.Rectangle{Min: srcBounds.Min, Max: srcBounds.Min.Add(image.Pt(srcWidth, srcHeight))}
+	if e.TileWidth > 0 || e.TileHeight > 0 {
+		return e.encodeTiles(paletted, src, width, height)
+	}
+	out := e.appendImage(e.outScratch[:0], paletted, src, width, height)
+	e.outScratch = out[:0]
+	if _, err := e.w.Write(out); err != nil {
+		return err
+	}
+	return nil
+}
 
-	out := e.outScratch[:0]
-	outCap := width*height/2 + len(paletted.Palette)*16 + 64
-	if cap(out) < outCap {
-		out = make([]byte, 0, outCap)
+// appendImage appends one DCS sixel sequence of size width x height to out,
+// drawing the src area of paletted at its top left corner.
+func (e *Encoder) appendImage(out []byte, paletted *image.Paletted, src image.Rectangle, width, height int) []byte {
+	if outCap := len(out) + width*height/2 + len(paletted.Palette)*16 + 64; cap(out) < outCap {
+		out = append(make([]byte, 0, outCap), out...)
 	}
+	out = e.appendHeader(out, paletted.Palette, width, height)
+	e.bands.reset(paletted.Palette, width)
+	var rows [6][]uint8
+	for z := 0; z < (height+5)/6; z++ {
+		n := 0
+		for p := 0; p < 6; p++ {
+			y := z*6 + p
+			if y >= src.Dy() {
+				break
+			}
+			offset := paletted.PixOffset(src.Min.X, src.Min.Y+y)
+			rows[p] = paletted.Pix[offset : offset+src.Dx()]
+			n++
+		}
+		out = e.bands.append(out, rows[:n])
+	}
+	// string terminator(ST)
+	return append(out, 0x1b, 0x5c)
+}
 
+// appendHeader appends the DCS introducer, raster attributes and the color
+// register definitions for palette.
+func (e *Encoder) appendHeader(out []byte, palette color.Palette, width, height int) []byte {
 	// DECSIXEL Introducer(\033P0;P2;8q) + DECGRA ("1;1;W;H): Set Raster Attributes
 	// P2=1 keeps the existing screen content behind transparent pixels,
 	// P2=0 paints them in the background color.
@@ -150,408 +220,192 @@ func (e *Encoder) Encode(img image.Image) error {
 	out = append(out, ';')
 	out = strconv.AppendInt(out, int64(height), 10)
 
-	for n, v := range paletted.Palette {
+	// Color registers are not shifted: slot 0 is an ordinary register per
+	// DEC STD 070; transparency comes from leaving pixels unencoded.
+	// Percentages are rounded so that decoded colors encode to the same
+	// registers again.
+	for n, v := range palette {
 		r, g, b, _ := v.RGBA()
-		r = r * 100 / 0xFFFF
-		g = g * 100 / 0xFFFF
-		b = b * 100 / 0xFFFF
+		r = (r*100 + 0x7FFF) / 0xFFFF
+		g = (g*100 + 0x7FFF) / 0xFFFF
+		b = (b*100 + 0x7FFF) / 0xFFFF
 		out = appendColorRegister(out, n, r, g, b)
 	}
+	return out
+}
 
+// sixelBands turns rows of palette indices into sixel data, six rows (one
+// band) at a time. Its buffers are kept across images to avoid
+// reallocations.
+type sixelBands struct {
+	width  int
+	n      int
+	ch0    byte
+	buf    []byte
+	seen   []uint16
+	opaque []byte
+	gen    uint16
+}
+
+// reset prepares b for an image of the given width drawn with palette.
+func (b *sixelBands) reset(palette color.Palette, width int) {
+	paletteSize := len(palette)
 	bufSize := width * paletteSize
-	buf := e.bitsetScratch
-	if cap(buf) < bufSize {
-		buf = make([]byte, bufSize)
+	if cap(b.buf) < bufSize {
+		b.buf = make([]byte, bufSize)
 	} else {
-		buf = buf[:bufSize]
-		clear(buf)
+		b.buf = b.buf[:bufSize]
+		clear(b.buf)
 	}
-	seen := e.seenScratch
-	if cap(seen) < paletteSize {
-		seen = make([]uint16, paletteSize)
+	if cap(b.seen) < paletteSize {
+		b.seen = make([]uint16, paletteSize)
 	} else {
-		seen = seen[:paletteSize]
+		b.seen = b.seen[:paletteSize]
 	}
-	var opaque []byte
-	if cap(e.opaqueScratch) < len(paletted.Palette) {
-		opaque = make([]byte, len(paletted.Palette))
+	if cap(b.opaque) < paletteSize {
+		b.opaque = make([]byte, paletteSize)
 	} else {
-		opaque = e.opaqueScratch[:len(paletted.Palette)]
+		b.opaque = b.opaque[:paletteSize]
 	}
-	for i, c := range paletted.Palette {
+	for i, c := range palette {
 		_, _, _, alpha := c.RGBA()
 		if alpha != 0 {
-			opaque[i] = 1
+			b.opaque[i] = 1
 		} else {
-			opaque[i] = 0
+			b.opaque[i] = 0
 		}
 	}
-	ch0 := specialChNr
-	for z := 0; z < (height+5)/6; z++ {
-		e.seenGen++
-		if e.seenGen == 0 {
-			for i := range seen {
-				seen[i] = 0
-			}
-			e.seenGen = 1
-		}
-		gen := e.seenGen
-		// DECGNL (-): Graphics Next Line
-		if z > 0 {
-			out = append(out, '-')
+	b.width = width
+	b.n = 0
+	b.ch0 = specialChNr
+}
+
+// append appends the next band to out. rows holds up to six rows of palette
+// indices, each at most b.width long.
+func (b *sixelBands) append(out []byte, rows [][]uint8) []byte {
+	b.gen++
+	if b.gen == 0 {
+		for i := range b.seen {
+			b.seen[i] = 0
 		}
-		for p := 0; p < 6; p++ {
-			y := z*6 + p
-			if y >= srcHeight {
+		b.gen = 1
+	}
+	gen := b.gen
+	width, buf, seen, opaque := b.width, b.buf, b.seen, b.opaque
+	// DECGNL (-): Graphics Next Line
+	if b.n > 0 {
+		out = append(out, '-')
+	}
+	b.n++
+	for p, row := range rows {
+		rowMask := byte(1 << uint(p))
+		for x, pix := range row {
+			if opaque[pix] == 0 {
 				continue
 			}
-			rowMask := byte(1 << uint(p))
-			offset := paletted.PixOffset(srcBounds.Min.X, srcBounds.Min.Y+y)
-			row := paletted.Pix[offset : offset+srcWidth]
-			for x, pix := range row {
-				if opaque[pix] == 0 {
-					continue
-				}
-				idx := int(pix)
-				seen[idx] = gen
-				buf[width*idx+x] |= rowMask
-			}
+			idx := int(pix)
+			seen[idx] = gen
+			buf[width*idx+x] |= rowMask
 		}
-		for n := 0; n < paletteSize; n++ {
-			if seen[n] != gen {
-				continue
-			}
-			// DECGCR ($): Graphics Carriage Return
-			if ch0 == specialChCr {
-				out = append(out, '$')
-			}
-			out = appendColorSelect(out, n)
-			cnt := 0
-			base := width * n
-			for x := 0; x < width; x++ {
-				// make sixel character from 6 pixels
-				ch := buf[base+x]
-				buf[base+x] = 0
-				if ch0 < 0x40 && ch != ch0 {
-					out = appendRun(out, ch0, cnt)
-					cnt = 0
-				}
-				ch0 = ch
-				cnt++
-			}
-			if ch0 != 0 {
+	}
+	ch0 := b.ch0
+	for n := range seen {
+		if seen[n] != gen {
+			continue
+		}
+		// DECGCR ($): Graphics Carriage Return
+		if ch0 == specialChCr {
+			out = append(out, '$')
+		}
+		out = appendColorSelect(out, n)
+		cnt := 0
+		base := width * n
+		for x := 0; x < width; x++ {
+			// make sixel character from 6 pixels
+			ch := buf[base+x]
+			buf[base+x] = 0
+			if ch0 < 0x40 && ch != ch0 {
 				out = appendRun(out, ch0, cnt)
+				cnt = 0
 			}
-			ch0 = specialChCr
+			ch0 = ch
+			cnt++
 		}
+		if ch0 != 0 {
+			out = appendRun(out, ch0, cnt)
+		}
+		ch0 = specialChCr
 	}
-	// string terminator(ST)
-	out = append(out, 0x1b, 0x5c)
-	e.outScratch = out[:0]
-	e.bitsetScratch = buf
-	e.seenScratch = seen
-	e.opaqueScratch = opaque
-	if _, err := e.w.Write(out); err != nil {
-		return err
-	}
-	return nil
-}
-
-// Decoder decode sixel format into image
-type Decoder struct {
-	r io.Reader
-}
-
-// NewDecoder return new instance of Decoder
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r}
+	b.ch0 = ch0
+	return out
 }
 
-// Decode do decoding from image
-func (e *Decoder) Decode(img *image.Image) error {
-	buf := bufio.NewReader(e.r)
-	_, err := buf.ReadBytes('\x1B')
-	if err != nil {
-		if err == io.EOF {
-			err = nil
-		}
+// encodeTiles writes the src area of paletted as a grid of sixel sequences
+// no larger than the tile size, all defining the same color registers.
+// Each row of tiles first reserves its text lines, so drawing does not
+// scroll, then places the tiles with DECSC/DECRC and CUF and finally moves
+// the cursor below the row with line feeds.
+func (e *Encoder) encodeTiles(paletted *image.Paletted, src image.Rectangle, width, height int) error {
+	tw, th := e.tileSize(width, height)
+	if tw >= width && th >= height {
+		out := e.appendImage(e.outScratch[:0], paletted, src, width, height)
+		e.outScratch = out[:0]
+		_, err := e.w.Write(out)
 		return err
 	}
-	c, err := buf.ReadByte()
-	if err != nil {
-		return err
+	if e.CellWidth <= 0 || e.CellHeight <= 0 {
+		return errors.New("sixel: tiling requires CellWidth and CellHeight")
 	}
-	switch c {
-	case 'P':
-		_, err := buf.ReadString('q')
-		if err != nil {
-			return err
+	out := e.outScratch[:0]
+	for ty := 0; ty < height; ty += th {
+		h := min(th, height-ty)
+		lines := (h + e.CellHeight - 1) / e.CellHeight
+		for i := 0; i < lines; i++ {
+			out = append(out, '\n')
 		}
-	default:
-		return errors.New("Invalid format: illegal header")
-	}
-	colors := map[uint]color.Color{
-		// 16 predefined color registers of VT340
-		0:  sixelRGB(0, 0, 0),
-		1:  sixelRGB(20, 20, 80),
-		2:  sixelRGB(80, 13, 13),
-		3:  sixelRGB(20, 80, 20),
-		4:  sixelRGB(80, 20, 80),
-		5:  sixelRGB(20, 80, 80),
-		6:  sixelRGB(80, 80, 20),
-		7:  sixelRGB(53, 53, 53),
-		8:  sixelRGB(26, 26, 26),
-		9:  sixelRGB(33, 33, 60),
-		10: sixelRGB(60, 26, 26),
-		11: sixelRGB(33, 60, 33),
-		12: sixelRGB(60, 33, 60),
-		13: sixelRGB(33, 60, 60),
-		14: sixelRGB(60, 60, 33),
-		15: sixelRGB(80, 80, 80),
-	}
-	dx, dy := 0, 0
-	dw, dh, w, h := 0, 0, 200, 200
-	pimg := image.NewNRGBA(image.Rect(0, 0, w, h))
-	var cn uint
-data:
-	for {
-		c, err = buf.ReadByte()
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return err
+		out = append(out, "\x1b["...)
+		out = strconv.AppendInt(out, int64(lines), 10)
+		out = append(out, 'A', 0x1b, '7')
+		for tx := 0; tx < width; tx += tw {
+			if tx > 0 {
+				out = append(out, 0x1b, '8', 0x1b, '[')
+				out = strconv.AppendInt(out, int64(tx/e.CellWidth), 10)
+				out = append(out, 'C')
+			}
+			w := min(tw, width-tx)
+			tile := image.Rect(tx, ty, tx+w, ty+h).Add(src.Min).Intersect(src)
+			out = e.appendImage(out, paletted, tile, w, h)
 		}
-		if c == '\r' || c == '\n' || c == '\b' {
-			continue
-		}
-		switch c {
-		case '\x1b':
-			c, err = buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			if c == '\\' {
-				break data
-			}
-		case '"':
-			params := []int{}
-			for {
-				var i int
-				n, err := fmt.Fscanf(buf, "%d", &i)
-				if err == io.EOF {
-					return err
-				}
-				if n == 0 {
-					i = 0
-				}
-				params = append(params, i)
-				c, err = buf.ReadByte()
-				if err != nil {
-					return err
-				}
-				if c != ';' {
-					break
-				}
-			}
-			if len(params) >= 4 {
-				if w < params[2] {
-					w = params[2]
-				}
-				if h < params[3]+6 {
-					h = params[3] + 6
-				}
-				pimg = expandImage(pimg, w, h)
-			}
-			err = buf.UnreadByte()
-			if err != nil {
-				return err
-			}
-		case '$':
-			dx = 0
-		case '!':
-			err = buf.UnreadByte()
-			if err != nil {
-				return err
-			}
-			var nc uint
-			var c byte
-			n, err := fmt.Fscanf(buf, "!%d%c", &nc, &c)
-			if err != nil {
-				return err
-			}
-			if n != 2 || c < '?' || c > '~' {
-				return fmt.Errorf("invalid format: illegal repeating data tokens '!%d%c'", nc, c)
-			}
-			pimg, w, h = ensureImageSize(pimg, dx+int(nc), dy+6)
-			m := byte(1)
-			c -= '?'
-			for p := 0; p < 6; p++ {
-				if c&m != 0 {
-					for q := 0; q < int(nc); q++ {
-						pimg.Set(dx+q, dy+p, colors[cn])
-					}
-					if dh < dy+p+1 {
-						dh = dy + p + 1
-					}
-				}
-				m <<= 1
-			}
-			dx += int(nc)
-			if dw < dx {
-				dw = dx
-			}
-		case '-':
-			dx = 0
-			dy += 6
-			pimg, w, h = ensureImageSize(pimg, w, dy+7)
-		case '#':
-			err = buf.UnreadByte()
-			if err != nil {
-				return err
-			}
-			var nc, csys uint
-			var r, g, b uint
-			var c byte
-			n, err := fmt.Fscanf(buf, "#%d%c", &nc, &c)
-			if err != nil {
-				return err
-			}
-			if n != 2 {
-				return fmt.Errorf("invalid format: illegal color specifier '#%d%c'", nc, c)
-			}
-			if c == ';' {
-				n, err := fmt.Fscanf(buf, "%d;%d;%d;%d", &csys, &r, &g, &b)
-				if err != nil {
-					return err
-				}
-				if n != 4 {
-					return fmt.Errorf("invalid format: illegal color specifier '#%d;%d;%d;%d;%d'", nc, csys, r, g, b)
-				}
-				if csys == 1 {
-					colors[nc] = sixelHLS(r, g, b)
-				} else {
-					colors[nc] = sixelRGB(r, g, b)
-				}
-			} else {
-				err = buf.UnreadByte()
-				if err != nil {
-					return err
-				}
-			}
-			cn = nc
-			if _, ok := colors[cn]; !ok {
-				return fmt.Errorf("invalid format: undefined color number %d", cn)
-			}
-		default:
-			if c >= '?' && c <= '~' {
-				pimg, w, h = ensureImageSize(pimg, dx+1, dy+6)
-				m := byte(1)
-				c -= '?'
-				for p := 0; p < 6; p++ {
-					if c&m != 0 {
-						pimg.Set(dx, dy+p, colors[cn])
-						if dh < dy+p+1 {
-							dh = dy + p + 1
-						}
-					}
-					m <<= 1
-				}
-				dx++
-				if dw < dx {
-					dw = dx
-				}
-				break
-			}
-			return errors.New("invalid format: illegal data tokens")
+		out = append(out, 0x1b, '8')
+		for i := 0; i < lines; i++ {
+			out = append(out, '\n')
 		}
 	}
-	rect := image.Rect(0, 0, dw, dh)
-	tmp := image.NewNRGBA(rect)
-	draw.Draw(tmp, rect, pimg, image.Point{0, 0}, draw.Src)
-	*img = tmp
-	return nil
-}
-
-func sixelRGB(r, g, b uint) color.Color {
-	return color.NRGBA{uint8(r * 0xFF / 100), uint8(g * 0xFF / 100), uint8(b * 0xFF / 100), 0xFF}
+	e.outScratch = out[:0]
+	_, err := e.w.Write(out)
+	return err
 }
 
-func sixelHLS(h, l, s uint) color.Color {
-	var r, g, b, max, min float64
-
-	/* https://wikimedia.org/api/rest_v1/media/math/render/svg/17e876f7e3260ea7fed73f69e19c71eb715dd09d */
-	/* https://wikimedia.org/api/rest_v1/media/math/render/svg/f6721b57985ad83db3d5b800dc38c9980eedde1d */
-	if l > 50 {
-		max = float64(l) + float64(s)*(1.0-float64(l)/100.0)
-		min = float64(l) - float64(s)*(1.0-float64(l)/100.0)
-	} else {
-		max = float64(l) + float64(s*l)/100.0
-		min = float64(l) - float64(s*l)/100.0
-	}
-
-	/* sixel hue color ring is roteted -120 degree from nowdays general one. */
-	h = (h + 240) % 360
-
-	/* https://wikimedia.org/api/rest_v1/media/math/render/svg/937e8abdab308a22ff99de24d645ec9e70f1e384 */
-	switch h / 60 {
-	case 0: /* 0 <= hue < 60 */
-		r = max
-		g = min + (max-min)*(float64(h)/60.0)
-		b = min
-	case 1: /* 60 <= hue < 120 */
-		r = min + (max-min)*(float64(120-h)/60.0)
-		g = max
-		b = min
-	case 2: /* 120 <= hue < 180 */
-		r = min
-		g = max
-		b = min + (max-min)*(float64(h-120)/60.0)
-	case 3: /* 180 <= hue < 240 */
-		r = min
-		g = min + (max-min)*(float64(240-h)/60.0)
-		b = max
-	case 4: /* 240 <= hue < 300 */
-		r = min + (max-min)*(float64(h-240)/60.0)
-		g = min
-		b = max
-	case 5: /* 300 <= hue < 360 */
-		r = max
-		g = min
-		b = min + (max-min)*(float64(360-h)/60.0)
-	default:
+// tileSize returns the size of the tiles an image of width x height is
+// split into: TileWidth and TileHeight, further limited by the geometry of
+// Profile and rounded down to whole character cells, as tiles are placed
+// at cell positions. Tiles smaller than a cell are made one cell.
+func (e *Encoder) tileSize(width, height int) (int, int) {
+	tw, th := e.TileWidth, e.TileHeight
+	if tw <= 0 {
+		tw = width
 	}
-	return sixelRGB(uint(r), uint(g), uint(b))
-}
-
-func expandImage(pimg *image.NRGBA, w, h int) *image.NRGBA {
-	b := pimg.Bounds()
-	if w < b.Max.X {
-		w = b.Max.X
+	if th <= 0 {
+		th = height
 	}
-	if h < b.Max.Y {
-		h = b.Max.Y
+	tw, th = e.Profile.clampSize(tw, th)
+	if e.CellWidth > 0 {
+		tw = max(tw-tw%e.CellWidth, e.CellWidth)
 	}
-	tmp := image.NewNRGBA(image.Rect(0, 0, w, h))
-	draw.Draw(tmp, b, pimg, image.Point{0, 0}, draw.Src)
-	return tmp
-}
-
-func ensureImageSize(pimg *image.NRGBA, w, h int) (*image.NRGBA, int, int) {
-	for {
-		b := pimg.Bounds()
-		if b.Max.X >= w && b.Max.Y >= h {
-			return pimg, b.Max.X, b.Max.Y
-		}
-		nw, nh := b.Max.X, b.Max.Y
-		for nw < w {
-			nw *= 2
-		}
-		for nh < h {
-			nh *= 2
-		}
-		pimg = expandImage(pimg, nw, nh)
+	if e.CellHeight > 0 {
+		th = max(th-th%e.CellHeight, e.CellHeight)
 	}
+	return tw, th
 }
 
 func appendColorRegister(dst []byte, n int, r, g, b uint32) []byte {
@@ -607,15 +461,12 @@ func toRGBA(img image.Image) *image.RGBA {
 // samplePalette builds an adaptive palette of at most maxColors colors using
 // the median cut algorithm. Large images are subsampled first: palette
 // quality barely depends on pixel count, while median cut cost does.
-func samplePalette(rgba *image.RGBA, maxColors int) color.Palette {
-	const budget = 1 << 18
+// If weights is not nil, about as many pixels again are drawn in proportion
+// to their weight, so heavily weighted regions get more of the palette.
+func samplePalette(rgba *image.RGBA, maxColors int, weights []float32) color.Palette {
 	b := rgba.Bounds()
-	src := image.Image(rgba)
-	if b.Dx()*b.Dy() > budget {
-		step := 2
-		for (b.Dx()/step)*(b.Dy()/step) > budget {
-			step++
-		}
+	src := rgba
+	if step := sampleStep(b.Dx(), b.Dy()); step > 1 {
 		sw, sh := b.Dx()/step, b.Dy()/step
 		sample := image.NewRGBA(image.Rect(0, 0, sw, sh))
 		for y := 0; y < sh; y++ {
@@ -628,9 +479,23 @@ func samplePalette(rgba *image.RGBA, maxColors int) color.Palette {
 		}
 		src = sample
 	}
+	if weights != nil {
+		src = appendWeightedSample(src, rgba, weights)
+	}
 	return median.Quantizer(0).Quantize(make(color.Palette, 0, maxColors), src)
 }
 
+// sampleStep returns the stride in both directions at which a width x height
+// image is subsampled to build a palette from at most 1<<18 pixels.
+func sampleStep(width, height int) int {
+	const budget = 1 << 18
+	step := 1
+	for (width/step)*(height/step) > budget {
+		step++
+	}
+	return step
+}
+
 // newPaletteLUT returns a lookup table from 15-bit RGB (5 bits per channel)
 // to the nearest palette index, replacing per-pixel linear palette searches.
 func newPaletteLUT(p color.Palette) []uint8 {
@@ -678,11 +543,40 @@ func mapPaletted(dst *image.Paletted, src *image.RGBA, lut []uint8) {
 // going through lut. Fully transparent pixels neither receive nor diffuse
 // error; they are remapped to the transparent palette entry afterwards.
 func ditherPaletted(dst *image.Paletted, src *image.RGBA, lut []uint8) {
-	var pr, pg, pb [256]int32
-	for i, c := range dst.Palette {
+	bd := src.Bounds()
+	w := bd.Dx()
+	d := newDitherer(dst.Palette, lut, w)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		so := src.PixOffset(bd.Min.X, y)
+		do := dst.PixOffset(bd.Min.X, y)
+		d.row(dst.Pix[do:do+w], src.Pix[so:so+4*w])
+	}
+}
+
+// ditherer carries the Floyd-Steinberg error of one row over to the next,
+// so images can be dithered row by row.
+type ditherer struct {
+	pr, pg, pb [256]int32
+	lut        []uint8
+	// accumulated quantization error, scaled by 16
+	cur, next [][3]int32
+}
+
+func newDitherer(p color.Palette, lut []uint8, width int) *ditherer {
+	d := &ditherer{
+		lut:  lut,
+		cur:  make([][3]int32, width+2),
+		next: make([][3]int32, width+2),
+	}
+	for i, c := range p {
 		r, g, b, _ := c.RGBA()
-		pr[i], pg[i], pb[i] = int32(r>>8), int32(g>>8), int32(b>>8)
+		d.pr[i], d.pg[i], d.pb[i] = int32(r>>8), int32(g>>8), int32(b>>8)
 	}
+	return d
+}
+
+// row dithers one row of RGBA pixels in src into palette indices in dst.
+func (d *ditherer) row(dst, src []uint8) {
 	clamp := func(v int32) uint8 {
 		if v < 0 {
 			return 0
@@ -692,45 +586,36 @@ func ditherPaletted(dst *image.Paletted, src *image.RGBA, lut []uint8) {
 		}
 		return uint8(v)
 	}
-	bd := src.Bounds()
-	w := bd.Dx()
-	// accumulated quantization error, scaled by 16
-	cur := make([][3]int32, w+2)
-	next := make([][3]int32, w+2)
-	for y := bd.Min.Y; y < bd.Max.Y; y++ {
-		so := src.PixOffset(bd.Min.X, y)
-		do := dst.PixOffset(bd.Min.X, y)
-		for x := 0; x < w; x++ {
-			if src.Pix[so+3] == 0 {
-				so += 4
-				do++
-				continue
-			}
-			r := clamp(int32(src.Pix[so]) + cur[x+1][0]/16)
-			g := clamp(int32(src.Pix[so+1]) + cur[x+1][1]/16)
-			b := clamp(int32(src.Pix[so+2]) + cur[x+1][2]/16)
-			idx := lut[lutIndex(r, g, b)]
-			dst.Pix[do] = idx
-			er, eg, eb := int32(r)-pr[idx], int32(g)-pg[idx], int32(b)-pb[idx]
-			cur[x+2][0] += er * 7
-			cur[x+2][1] += eg * 7
-			cur[x+2][2] += eb * 7
-			next[x][0] += er * 3
-			next[x][1] += eg * 3
-			next[x][2] += eb * 3
-			next[x+1][0] += er * 5
-			next[x+1][1] += eg * 5
-			next[x+1][2] += eb * 5
-			next[x+2][0] += er
-			next[x+2][1] += eg
-			next[x+2][2] += eb
+	cur, next := d.cur, d.next
+	so := 0
+	for x := range dst {
+		if src[so+3] == 0 {
 			so += 4
-			do++
-		}
-		cur, next = next, cur
-		for i := range next {
-			next[i] = [3]int32{}
+			continue
 		}
+		r := clamp(int32(src[so]) + cur[x+1][0]/16)
+		g := clamp(int32(src[so+1]) + cur[x+1][1]/16)
+		b := clamp(int32(src[so+2]) + cur[x+1][2]/16)
+		idx := d.lut[lutIndex(r, g, b)]
+		dst[x] = idx
+		er, eg, eb := int32(r)-d.pr[idx], int32(g)-d.pg[idx], int32(b)-d.pb[idx]
+		cur[x+2][0] += er * 7
+		cur[x+2][1] += eg * 7
+		cur[x+2][2] += eb * 7
+		next[x][0] += er * 3
+		next[x][1] += eg * 3
+		next[x][2] += eb * 3
+		next[x+1][0] += er * 5
+		next[x+1][1] += eg * 5
+		next[x+1][2] += eb * 5
+		next[x+2][0] += er
+		next[x+2][1] += eg
+		next[x+2][2] += eb
+		so += 4
+	}
+	d.cur, d.next = next, cur
+	for i := range cur {
+		cur[i] = [3]int32{}
 	}
 }
 
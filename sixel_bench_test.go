@@ -1,6 +1,7 @@
 package sixel
 
 import (
+	"bytes"
 	"image"
 	"image/color"
 	"io"
@@ -121,3 +122,28 @@ func BenchmarkEncodeRGBA320x240(b *testing.B) {
 		}
 	}
 }
+
+func benchmarkDecode(b *testing.B, img image.Image) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(img); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var decoded image.Image
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePaletted320x240(b *testing.B) {
+	benchmarkDecode(b, benchmarkPalettedImage(320, 240))
+}
+
+func BenchmarkDecodeQuantize1920x1080(b *testing.B) {
+	benchmarkDecode(b, benchmarkGradientImage(1920, 1080))
+}
@@ -124,3 +124,107 @@ func TestEncodeTransparent(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeProfile(t *testing.T) {
+	img := benchmarkGradientImage(1000, 600)
+
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+	enc.Profile = ProfileVT340
+	if err := enc.Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("\"1;1;800;480")) {
+		t.Fatalf("raster attributes not clamped to the VT340 geometry")
+	}
+	if bytes.Contains(out.Bytes(), []byte("#16;")) {
+		t.Fatalf("color register above the VT340 limit was defined")
+	}
+
+	var decoded image.Image
+	if err := NewDecoder(&out).Decode(&decoded); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if b := decoded.Bounds(); b.Dx() != 800 || b.Dy() != 480 {
+		t.Fatalf("unexpected size: got %dx%d want 800x480", b.Dx(), b.Dy())
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	for _, p := range Profiles {
+		if got := LookupProfile(p.Name); got != p {
+			t.Fatalf("LookupProfile(%q) = %v", p.Name, got)
+		}
+	}
+	if got := LookupProfile("XTerm"); got != ProfileXterm {
+		t.Fatalf("LookupProfile is case sensitive")
+	}
+	if got := LookupProfile("vt100"); got != nil {
+		t.Fatalf("LookupProfile(%q) = %v, want nil", "vt100", got)
+	}
+}
+
+func TestEncodeTiles(t *testing.T) {
+	img := benchmarkPalettedImage(20, 30)
+
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+	enc.Transparent = true
+	enc.TileWidth = 10
+	enc.TileHeight = 14
+	enc.CellWidth = 10
+	enc.CellHeight = 6
+	if err := enc.Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	// Tiles are rounded down to whole cells: 10x12, giving 2x3 tiles.
+	seqs := bytes.Split(out.Bytes(), []byte("\x1bP"))[1:]
+	if len(seqs) != 6 {
+		t.Fatalf("got %d sixel sequences, want 6", len(seqs))
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("\n\n\x1b[2A\x1b7\x1bP")) {
+		t.Fatalf("first tile row does not reserve its lines: %q", out.Bytes()[:16])
+	}
+	if !bytes.Contains(out.Bytes(), []byte("\x1b\\\x1b8\x1b[1C\x1bP")) {
+		t.Fatalf("second tile is not placed next to the first")
+	}
+	for i, seq := range seqs {
+		var decoded image.Image
+		if err := NewDecoder(bytes.NewReader(append([]byte("\x1bP"), seq...))).Decode(&decoded); err != nil {
+			t.Fatalf("tile %d: Decode returned error: %v", i, err)
+		}
+		ox, oy := i%2*10, i/2*12
+		for y := 0; y < 12; y++ {
+			for x := 0; x < 10; x++ {
+				want := img.At(ox+x, oy+y)
+				got := decoded.At(x, y)
+				_, _, _, wa := want.RGBA()
+				_, _, _, ga := got.RGBA()
+				if wa == 0 && ga == 0 {
+					continue
+				}
+				if color.NRGBAModel.Convert(got) != color.NRGBAModel.Convert(want) {
+					t.Fatalf("tile %d pixel (%d,%d): got %v want %v", i, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	// Tiles narrower than a cell are made one cell wide.
+	out.Reset()
+	enc.TileWidth = 5
+	if err := enc.Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("\x1bP")); n != 6 {
+		t.Fatalf("got %d sixel sequences with 5 pixel wide tiles, want 6", n)
+	}
+	if bytes.Contains(out.Bytes(), []byte("\x1b[0C")) || bytes.Count(out.Bytes(), []byte("\x1b[1C")) != 3 {
+		t.Fatalf("tiles narrower than a cell are misplaced: %q", out.Bytes())
+	}
+
+	enc.CellWidth = 0
+	if err := enc.Encode(img); err == nil {
+		t.Fatalf("Encode tiled without cell size")
+	}
+}
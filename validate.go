@@ -0,0 +1,214 @@
+package sixel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Diagnostic is a problem found by Validate.
+type Diagnostic struct {
+	// Offset is the byte offset in the stream the problem was found at.
+	// Line and Column locate the same byte, both counted from 1, with
+	// lines separated by '\n' and columns counted in bytes.
+	Offset       int64
+	Line, Column int
+
+	// Warning is false for violations of the sixel format and true for
+	// portability problems: valid data that terminals interpret
+	// differently or that the profile given to Validate does not support.
+	Warning bool
+
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	kind := "error"
+	if d.Warning {
+		kind = "warning"
+	}
+	return fmt.Sprintf("%d:%d: %s: %s (offset %d)", d.Line, d.Column, kind, d.Message, d.Offset)
+}
+
+// Validate checks every sixel image in r and returns the problems found,
+// ordered by offset. Text between images is not checked. Besides invalid
+// data it reports color registers selected without being defined, color
+// coordinates out of range, repeat counts of zero, painting outside the
+// raster size, images without a string terminator and mixed 7-bit and
+// 8-bit controls. If p is not nil, registers, image sizes and features
+// beyond the limits of p are reported as well. The error is only set if
+// reading r fails.
+func Validate(r io.Reader, p *Profile) ([]Diagnostic, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{profile: p}
+	cr := &countingReader{r: bytes.NewReader(data)}
+	br := bufio.NewReader(cr)
+	var text []byte
+	for {
+		text = text[:0]
+		d := decoder{
+			br:      br,
+			cr:      cr,
+			text:    &text,
+			lenient: true,
+			measure: true,
+			lint:    l,
+			// measuring allocates no canvas, so no limits are needed
+			limits: limits{maxParam, maxParam, maxParam, maxColorRegisters},
+		}
+		found, err := d.readIntroducer()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			break
+		}
+		if err := d.readData(); err != nil {
+			// only register numbers can exceed the limits
+			l.errorf(d.pos()-1, "%v", err)
+		}
+	}
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		return l.diags[i].Offset < l.diags[j].Offset
+	})
+	// the diagnostics are sorted, so lines are counted from the previous one
+	line, lineStart, prev := 1, 0, 0
+	for i := range l.diags {
+		off := int(l.diags[i].Offset)
+		if n := bytes.Count(data[prev:off], []byte{'\n'}); n > 0 {
+			line += n
+			lineStart = prev + bytes.LastIndexByte(data[prev:off], '\n') + 1
+		}
+		prev = off
+		l.diags[i].Line = line
+		l.diags[i].Column = off - lineStart + 1
+	}
+	return l.diags, nil
+}
+
+// linter collects the diagnostics of Validate from the decoder.
+type linter struct {
+	profile *Profile
+	diags   []Diagnostic
+
+	// registers defined by the current image
+	defined map[int]bool
+	// offset and form of the introducer of the current image
+	start    int64
+	eightBit bool
+	// forms of introducers seen so far
+	seen7, seen8, mixed bool
+}
+
+func (l *linter) errorf(off int64, format string, args ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{Offset: off, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) warnf(off int64, format string, args ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{Offset: off, Warning: true, Message: fmt.Sprintf(format, args...)})
+}
+
+// begin starts an image whose introducer is at off.
+func (l *linter) begin(off int64, eightBit bool) {
+	l.defined = make(map[int]bool)
+	l.start, l.eightBit = off, eightBit
+	if eightBit {
+		l.seen8 = true
+		l.checkEightBit(off, "DCS")
+	} else {
+		l.seen7 = true
+	}
+	if l.seen7 && l.seen8 && !l.mixed {
+		l.mixed = true
+		l.warnf(off, "stream mixes 7-bit and 8-bit DCS introducers")
+	}
+}
+
+// checkEightBit reports an 8-bit control the profile does not support.
+func (l *linter) checkEightBit(off int64, name string) {
+	if l.profile != nil && !l.profile.EightBit {
+		l.warnf(off, "8-bit %s is not supported by %s", name, l.profile.Name)
+	}
+}
+
+// color checks the color introducer at off with parameters params.
+func (l *linter) color(off int64, params []int) {
+	nc := params[0]
+	if p := l.profile; p != nil && p.Colors > 0 && nc >= p.Colors {
+		l.warnf(off, "color register %d is beyond the %d registers of %s", nc, p.Colors, p.Name)
+	}
+	if len(params) == 1 {
+		switch {
+		case l.defined[nc]:
+		case nc < len(vt340Colors):
+			l.warnf(off, "color register %d selected without being defined; its color depends on the terminal", nc)
+		default:
+			l.errorf(off, "undefined color register %d selected", nc)
+		}
+		return
+	}
+	l.defined[nc] = true
+	if len(params) != 5 {
+		l.errorf(off, "color introducer for register %d has %d parameters, want 1 or 5", nc, len(params))
+		return
+	}
+	switch params[1] {
+	case 1:
+		if params[2] > 360 {
+			l.errorf(off, "hue %d out of range 0-360", params[2])
+		}
+		for _, v := range params[3:] {
+			if v > 100 {
+				l.errorf(off, "HLS percentage %d out of range 0-100", v)
+			}
+		}
+		if l.profile != nil && !l.profile.HLS {
+			l.warnf(off, "HLS colors are not supported by %s", l.profile.Name)
+		}
+	case 2:
+		for _, v := range params[2:] {
+			if v > 100 {
+				l.errorf(off, "RGB percentage %d out of range 0-100", v)
+			}
+		}
+	default:
+		l.errorf(off, "unknown color coordinate system %d", params[1])
+	}
+}
+
+// end finishes the image decoded by d. off is the offset of its string
+// terminator, or -1 if there is none.
+func (l *linter) end(d *decoder, off int64, eightBit bool) {
+	if off < 0 {
+		l.errorf(d.pos(), "missing string terminator")
+	} else {
+		if eightBit != l.eightBit {
+			if eightBit {
+				l.warnf(off, "7-bit DCS terminated by 8-bit ST")
+			} else {
+				l.warnf(off, "8-bit DCS terminated by 7-bit ST")
+			}
+		}
+		if eightBit {
+			l.checkEightBit(off, "ST")
+		}
+	}
+	if d.raster && d.ph > 0 && d.pv > 0 && (d.dw > d.ph || d.dh > d.pv) {
+		l.warnf(l.start, "painted area %dx%d exceeds the raster size %dx%d", d.dw, d.dh, d.ph, d.pv)
+	}
+	if p := l.profile; p != nil {
+		w, h := d.size()
+		if p.MaxWidth > 0 && w > p.MaxWidth {
+			l.warnf(l.start, "image width %d exceeds the limit %d of %s", w, p.MaxWidth, p.Name)
+		}
+		if p.MaxHeight > 0 && h > p.MaxHeight {
+			l.warnf(l.start, "image height %d exceeds the limit %d of %s", h, p.MaxHeight, p.Name)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package sixel
+
+import (
+	"bytes"
+	"image"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		profile *Profile
+		want    []string // "line:column: kind: message" prefixes
+	}{
+		{
+			name:  "undefined registers",
+			input: "\x1bPq#3~#20~\x1b\\",
+			want: []string{
+				"1:4: warning: color register 3 selected without being defined",
+				"1:7: error: undefined color register 20 selected",
+			},
+		},
+		{
+			name:  "out of range colors",
+			input: "\x1bPq#1;2;101;0;0#2;1;400;50;50#3;3;0;0;0#4;2;0~\x1b\\",
+			want: []string{
+				"1:4: error: RGB percentage 101 out of range 0-100",
+				"1:16: error: hue 400 out of range 0-360",
+				"1:30: error: unknown color coordinate system 3",
+				"1:40: error: color introducer for register 4 has 3 parameters",
+			},
+		},
+		{
+			name:  "zero repeat and invalid data",
+			input: "text\n\x1bPq#1;2;0;0;0!0~ !3#~\x1b\\",
+			want: []string{
+				"2:14: warning: repeat count of zero",
+				"2:17: error: invalid format: illegal data tokens ' '",
+				"2:18: error: invalid format: illegal repeating data tokens '!3#'",
+				// the '#' is read again as a color introducer
+				"2:20: warning: color register 0 selected without being defined",
+			},
+		},
+		{
+			name:  "raster size",
+			input: "\x1bPq\"1;1;2;6#1;2;0;0;0~~~\x1b\\",
+			want:  []string{"1:1: warning: painted area 3x6 exceeds the raster size 2x6"},
+		},
+		{
+			name:  "missing ST",
+			input: "\x1bPq#1;2;0;0;0~~\n",
+			want:  []string{"2:1: error: missing string terminator"},
+		},
+		{
+			name:  "mixed controls",
+			input: "\x1bPq~\x9c\n\x90q~\x1b\\",
+			want: []string{
+				"1:5: warning: 7-bit DCS terminated by 8-bit ST",
+				"2:1: warning: stream mixes 7-bit and 8-bit DCS introducers",
+				"2:4: warning: 8-bit DCS terminated by 7-bit ST",
+			},
+		},
+		{
+			name:    "profile limits",
+			input:   "\x90q\"1;1;900;6#20;1;0;50;50!900~\x9c",
+			profile: ProfileWezTerm,
+			want: []string{
+				"1:1: warning: 8-bit DCS is not supported by wezterm",
+				"1:1: warning: image width 900 exceeds the limit 800",
+				"1:31: warning: 8-bit ST is not supported by wezterm",
+			},
+		},
+	} {
+		if tt.profile == ProfileWezTerm {
+			// a copy with a width limit to exercise that check too
+			p := *ProfileWezTerm
+			p.MaxWidth = 800
+			tt.profile = &p
+		}
+		diags, err := Validate(strings.NewReader(tt.input), tt.profile)
+		if err != nil {
+			t.Fatalf("%s: Validate returned error: %v", tt.name, err)
+		}
+		if len(diags) != len(tt.want) {
+			t.Errorf("%s: got %d diagnostics, want %d: %v", tt.name, len(diags), len(tt.want), diags)
+			continue
+		}
+		for i, d := range diags {
+			if !strings.HasPrefix(d.String(), tt.want[i]) {
+				t.Errorf("%s: diagnostic %d: got %q, want %q", tt.name, i, d.String(), tt.want[i])
+			}
+		}
+	}
+}
+
+func TestValidateManyDiagnostics(t *testing.T) {
+	// a warning on each of many lines
+	const n = 50000
+	input := "\x1bPq#0;2;0;0;0" + strings.Repeat("\n~!0~", n) + "\x1b\\"
+	diags, err := Validate(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+	if len(diags) != n {
+		t.Fatalf("got %d diagnostics, want %d", len(diags), n)
+	}
+	if d := diags[n-1]; d.Line != n+1 || d.Column != 2 {
+		t.Fatalf("last diagnostic at %d:%d, want %d:2", d.Line, d.Column, n+1)
+	}
+}
+
+func TestValidateEncoderOutput(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	diags, err := Validate(&buf, ProfileXterm)
+	if err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+	if len(diags) != 0 {
+		t.Fatalf("Validate reported %v", diags)
+	}
+}
+
+// allocated returns the number of bytes allocated while running f.
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestValidateLargeRaster(t *testing.T) {
+	input := "\x1bPq\"1;1;12000;12000#0~\x1b\\"
+	n := allocated(func() {
+		if _, err := Validate(strings.NewReader(input), nil); err != nil {
+			t.Fatalf("Validate returned error: %v", err)
+		}
+	})
+	if n > 1<<20 {
+		t.Fatalf("Validate allocated %d bytes for a declared raster", n)
+	}
+}
@@ -0,0 +1,397 @@
+package vt
+
+import (
+	"image/color"
+	"unicode/utf8"
+)
+
+// parser states
+const (
+	stateGround = iota
+	stateEsc
+	// escape sequence with intermediate bytes, such as ESC ( B
+	stateEscInter
+	stateCSI
+	stateOSC
+	stateOSCEsc
+	stateDCS
+	stateDCSEsc
+)
+
+// feed interprets the byte c.
+func (t *Terminal) feed(c byte) {
+	switch c {
+	case 0x18, 0x1a: // CAN, SUB
+		t.flushUTF8()
+		t.state = stateGround
+		return
+	case 0x1b:
+		t.flushUTF8()
+		switch t.state {
+		case stateOSC:
+			t.state = stateOSCEsc
+		case stateDCS:
+			t.state = stateDCSEsc
+		default:
+			t.state = stateEsc
+		}
+		return
+	}
+
+	switch t.state {
+	case stateGround:
+		t.ground(c)
+	case stateEsc:
+		t.esc(c)
+	case stateEscInter:
+		if c >= 0x30 && c < 0x7f {
+			t.state = stateGround
+		}
+	case stateCSI:
+		if c >= 0x40 && c < 0x7f {
+			t.state = stateGround
+			t.csi(t.buf, c)
+		} else if c >= 0x20 {
+			t.buf = append(t.buf, c)
+		} else {
+			t.control(c)
+		}
+	case stateOSC:
+		if c == 0x07 || c == 0x9c {
+			t.state = stateGround
+		}
+	case stateOSCEsc:
+		t.state = stateGround
+		if c != '\\' {
+			t.esc(c)
+		}
+	case stateDCS:
+		if c == 0x9c {
+			t.state = stateGround
+			t.dcs(t.buf)
+		} else {
+			t.buf = append(t.buf, c)
+		}
+	case stateDCSEsc:
+		// any escape ends the string, as ST does
+		t.state = stateGround
+		t.dcs(t.buf)
+		if c != '\\' {
+			t.esc(c)
+		}
+	}
+}
+
+// ground handles c outside of control sequences. C1 controls are only
+// recognized where they cannot be part of a UTF-8 sequence.
+func (t *Terminal) ground(c byte) {
+	switch {
+	case c < 0x20 || c == 0x7f:
+		t.flushUTF8()
+		t.control(c)
+	case c < 0x80:
+		t.flushUTF8()
+		t.put(rune(c))
+	case c < 0xa0 && len(t.utf) == 0:
+		t.c1(c)
+	default:
+		t.utf = append(t.utf, c)
+		if utf8.FullRune(t.utf) {
+			r, n := utf8.DecodeRune(t.utf)
+			if n < len(t.utf) {
+				r = utf8.RuneError
+			}
+			t.utf = t.utf[:0]
+			t.put(r)
+		}
+	}
+}
+
+// flushUTF8 writes an incomplete UTF-8 sequence as U+FFFD.
+func (t *Terminal) flushUTF8() {
+	if len(t.utf) > 0 {
+		t.utf = t.utf[:0]
+		t.put(utf8.RuneError)
+	}
+}
+
+// control handles the C0 control c.
+func (t *Terminal) control(c byte) {
+	switch c {
+	case '\b':
+		t.moveTo(t.x-1, t.y)
+	case '\t':
+		t.moveTo((t.x/8+1)*8, t.y)
+	case '\n', '\v', '\f':
+		t.index()
+		if t.NewLine {
+			t.x = 0
+		}
+	case '\r':
+		t.moveTo(0, t.y)
+	}
+}
+
+// c1 handles the 8-bit control c.
+func (t *Terminal) c1(c byte) {
+	switch c {
+	case 0x84: // IND
+		t.index()
+	case 0x85: // NEL
+		t.x = 0
+		t.index()
+	case 0x8d: // RI
+		t.reverseIndex()
+	case 0x90: // DCS
+		t.state, t.buf = stateDCS, t.buf[:0]
+	case 0x9b: // CSI
+		t.state, t.buf = stateCSI, t.buf[:0]
+	case 0x9d: // OSC
+		t.state = stateOSC
+	}
+}
+
+// esc handles the byte c following ESC.
+func (t *Terminal) esc(c byte) {
+	t.state = stateGround
+	switch {
+	case c >= 0x20 && c < 0x30:
+		t.state = stateEscInter
+	case c == '[':
+		t.state, t.buf = stateCSI, t.buf[:0]
+	case c == ']':
+		t.state = stateOSC
+	case c == 'P':
+		t.state, t.buf = stateDCS, t.buf[:0]
+	case c == '7': // DECSC
+		t.save = saved{t.x, t.y, t.pen}
+	case c == '8': // DECRC
+		t.restore()
+	case c == 'D':
+		t.index()
+	case c == 'E':
+		t.x = 0
+		t.index()
+	case c == 'M':
+		t.reverseIndex()
+	case c == 'c': // RIS
+		t.reset()
+	}
+}
+
+func (t *Terminal) restore() {
+	t.moveTo(t.save.x, t.save.y)
+	t.pen = t.save.pen
+}
+
+// csi handles the control sequence CSI seq final.
+func (t *Terminal) csi(seq []byte, final byte) {
+	var private byte
+	if len(seq) > 0 && seq[0] >= 0x3c && seq[0] <= 0x3f {
+		private, seq = seq[0], seq[1:]
+	}
+	var params []int
+	n, digits := 0, false
+	for _, c := range seq {
+		switch {
+		case c >= '0' && c <= '9':
+			n = min(n*10+int(c-'0'), 1<<16)
+			digits = true
+		case c == ';':
+			params = append(params, n)
+			n, digits = 0, false
+		default:
+			// intermediate bytes select other functions
+			return
+		}
+	}
+	if digits || len(params) > 0 {
+		params = append(params, n)
+	}
+	// arg returns parameter i, or def if it is missing or 0
+	arg := func(i, def int) int {
+		if i < len(params) && params[i] > 0 {
+			return params[i]
+		}
+		return def
+	}
+
+	if private != 0 {
+		if private == '?' && (final == 'h' || final == 'l') {
+			for _, p := range params {
+				t.setMode(p, final == 'h')
+			}
+		}
+		return
+	}
+	switch final {
+	case 'A':
+		t.moveTo(t.x, t.y-arg(0, 1))
+	case 'B':
+		t.moveTo(t.x, t.y+arg(0, 1))
+	case 'C':
+		t.moveTo(t.x+arg(0, 1), t.y)
+	case 'D':
+		t.moveTo(t.x-arg(0, 1), t.y)
+	case 'E':
+		t.moveTo(0, t.y+arg(0, 1))
+	case 'F':
+		t.moveTo(0, t.y-arg(0, 1))
+	case 'G', '`':
+		t.moveTo(arg(0, 1)-1, t.y)
+	case 'H', 'f':
+		t.moveTo(arg(1, 1)-1, arg(0, 1)-1)
+	case 'd':
+		t.moveTo(t.x, arg(0, 1)-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			t.clear(t.y, t.x, t.cols)
+			for y := t.y + 1; y < t.rows; y++ {
+				t.clear(y, 0, t.cols)
+			}
+		case 1:
+			for y := 0; y < t.y; y++ {
+				t.clear(y, 0, t.cols)
+			}
+			t.clear(t.y, 0, t.x+1)
+		case 2, 3:
+			for y := 0; y < t.rows; y++ {
+				t.clear(y, 0, t.cols)
+			}
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			t.clear(t.y, t.x, t.cols)
+		case 1:
+			t.clear(t.y, 0, t.x+1)
+		case 2:
+			t.clear(t.y, 0, t.cols)
+		}
+	case 'X':
+		t.clear(t.y, t.x, t.x+arg(0, 1))
+	case 'S':
+		t.scrollUp(arg(0, 1))
+	case 'T':
+		t.scrollDown(arg(0, 1))
+	case 'h', 'l':
+		for _, p := range params {
+			if p == 20 {
+				t.NewLine = final == 'h'
+			}
+		}
+	case 'm':
+		t.sgr(params)
+	case 's':
+		t.save = saved{t.x, t.y, t.pen}
+	case 'u':
+		t.restore()
+	}
+}
+
+// setMode sets or resets the DEC private mode p.
+func (t *Terminal) setMode(p int, set bool) {
+	switch p {
+	case 7:
+		t.autowrap = set
+	case 80: // DECSDM
+		t.sixelScrolling = !set
+	case 8452:
+		t.sixelCursorRight = set
+	}
+}
+
+// sgr sets character attributes.
+func (t *Terminal) sgr(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			t.pen = pen{fg: palette[defaultFG], bg: palette[defaultBG]}
+		case p == 7:
+			t.pen.reverse = true
+		case p == 27:
+			t.pen.reverse = false
+		case p >= 30 && p <= 37:
+			t.pen.fg = palette[p-30]
+		case p == 39:
+			t.pen.fg = palette[defaultFG]
+		case p >= 40 && p <= 47:
+			t.pen.bg = palette[p-40]
+		case p == 49:
+			t.pen.bg = palette[defaultBG]
+		case p >= 90 && p <= 97:
+			t.pen.fg = palette[p-90+8]
+		case p >= 100 && p <= 107:
+			t.pen.bg = palette[p-100+8]
+		case p == 38 || p == 48:
+			c, n, ok := extendedColor(params[i+1:])
+			i += n
+			if !ok {
+				continue
+			}
+			if p == 38 {
+				t.pen.fg = c
+			} else {
+				t.pen.bg = c
+			}
+		}
+	}
+}
+
+// extendedColor reads the color of SGR 38 or 48 from params: 5;n for an
+// indexed color or 2;r;g;b for a direct one. It returns the number of
+// parameters used.
+func extendedColor(params []int) (color.RGBA, int, bool) {
+	switch {
+	case len(params) >= 2 && params[0] == 5:
+		if params[1] < len(palette) {
+			return palette[params[1]], 2, true
+		}
+		return color.RGBA{}, 2, false
+	case len(params) >= 4 && params[0] == 2:
+		return color.RGBA{uint8(params[1]), uint8(params[2]), uint8(params[3]), 0xff}, 4, true
+	}
+	return color.RGBA{}, len(params), false
+}
+
+// dcs handles the device control string data, drawing sixel images.
+func (t *Terminal) dcs(data []byte) {
+	for _, c := range data {
+		if c == 'q' {
+			t.sixel(data)
+			return
+		}
+		if (c < '0' || c > '9') && c != ';' {
+			return
+		}
+	}
+}
+
+const (
+	defaultFG = 7
+	defaultBG = 0
+)
+
+// palette holds the 256 indexed colors of xterm.
+var palette = func() [256]color.RGBA {
+	var p [256]color.RGBA
+	for i, c := range [16]uint32{
+		0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+		0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+	} {
+		p[i] = color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 0xff}
+	}
+	levels := [6]uint8{0, 95, 135, 175, 215, 255}
+	for i := 0; i < 216; i++ {
+		p[16+i] = color.RGBA{levels[i/36], levels[i/6%6], levels[i%6], 0xff}
+	}
+	for i := 0; i < 24; i++ {
+		v := uint8(8 + 10*i)
+		p[232+i] = color.RGBA{v, v, v, 0xff}
+	}
+	return p
+}()
@@ -0,0 +1,270 @@
+// Package vt emulates a terminal screen, so that programs printing sixel
+// images can be tested without a terminal. A Terminal interprets the text,
+// control sequences and sixel images written to it and renders them into an
+// in-memory framebuffer, which tests can compare against golden images.
+//
+// Only what is commonly used to place text and images is supported: cursor
+// movement, save and restore, erasing, scrolling, SGR colors and the modes
+// affecting sixel images. Other sequences are ignored. Characters are all
+// one cell wide.
+package vt
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/mattn/go-sixel"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Terminal is a terminal screen of a fixed number of character cells.
+type Terminal struct {
+	// NewLine, if true, makes line feeds also return to the first column,
+	// as the tty driver does for the output of programs. Set it when
+	// writing what a program prints, and leave it unset for output
+	// captured from a terminal, such as a typescript.
+	NewLine bool
+
+	cols, rows int
+	cw, ch     int
+	fb         *image.RGBA
+	cells      []cell
+
+	// cursor position and whether a character written at the last column
+	// left a wrap pending
+	x, y int
+	wrap bool
+	pen  pen
+	save saved
+
+	// modes
+	autowrap         bool
+	sixelScrolling   bool
+	sixelCursorRight bool
+
+	// parser state
+	state int
+	buf   []byte
+	utf   []byte
+}
+
+// cell is a character on the screen.
+type cell struct {
+	r      rune
+	fg, bg color.RGBA
+}
+
+// pen holds the attributes characters are written with.
+type pen struct {
+	fg, bg  color.RGBA
+	reverse bool
+}
+
+// saved holds the state saved by DECSC (ESC 7) and SCOSC (CSI s).
+type saved struct {
+	x, y int
+	pen  pen
+}
+
+// New returns a terminal of cols x rows character cells, each cellWidth x
+// cellHeight pixels.
+func New(cols, rows, cellWidth, cellHeight int) *Terminal {
+	t := &Terminal{
+		cols: max(cols, 1),
+		rows: max(rows, 1),
+		cw:   max(cellWidth, 1),
+		ch:   max(cellHeight, 1),
+	}
+	t.fb = image.NewRGBA(image.Rect(0, 0, t.cols*t.cw, t.rows*t.ch))
+	t.cells = make([]cell, t.cols*t.rows)
+	t.reset()
+	return t
+}
+
+// reset returns the terminal to its initial state with a blank screen.
+func (t *Terminal) reset() {
+	t.x, t.y, t.wrap = 0, 0, false
+	t.pen = pen{fg: palette[defaultFG], bg: palette[defaultBG]}
+	t.save = saved{pen: t.pen}
+	t.autowrap = true
+	t.sixelScrolling = true
+	t.sixelCursorRight = false
+	t.state = stateGround
+	t.buf = t.buf[:0]
+	t.utf = t.utf[:0]
+	for y := 0; y < t.rows; y++ {
+		t.clear(y, 0, t.cols)
+	}
+}
+
+// Image returns the framebuffer. It is updated by later writes.
+func (t *Terminal) Image() *image.RGBA {
+	return t.fb
+}
+
+// Cursor returns the column and row of the cursor, counted from 0.
+func (t *Terminal) Cursor() (col, row int) {
+	return t.x, t.y
+}
+
+// Text returns the characters on the screen, one line per row with
+// trailing blanks and blank lines removed. Images drawn over characters do
+// not change them.
+func (t *Terminal) Text() string {
+	var b bytes.Buffer
+	for y := 0; y < t.rows; y++ {
+		line := make([]rune, t.cols)
+		for x := range line {
+			line[x] = t.cells[y*t.cols+x].r
+		}
+		b.WriteString(string(bytes.TrimRight([]byte(string(line)), " ")))
+		b.WriteByte('\n')
+	}
+	return string(bytes.TrimRight(b.Bytes(), "\n"))
+}
+
+// Write interprets p. It never fails.
+func (t *Terminal) Write(p []byte) (int, error) {
+	for _, c := range p {
+		t.feed(c)
+	}
+	return len(p), nil
+}
+
+// put writes r at the cursor and advances it.
+func (t *Terminal) put(r rune) {
+	if t.wrap && t.autowrap {
+		t.x = 0
+		t.index()
+	}
+	fg, bg := t.pen.fg, t.pen.bg
+	if t.pen.reverse {
+		fg, bg = bg, fg
+	}
+	t.cells[t.y*t.cols+t.x] = cell{r, fg, bg}
+	t.drawCell(t.x, t.y)
+	if t.x < t.cols-1 {
+		t.x++
+	} else {
+		t.wrap = true
+	}
+}
+
+// drawCell renders the cell at column x and row y into the framebuffer.
+func (t *Terminal) drawCell(x, y int) {
+	c := t.cells[y*t.cols+x]
+	r := image.Rect(x*t.cw, y*t.ch, (x+1)*t.cw, (y+1)*t.ch)
+	draw.Draw(t.fb, r, image.NewUniform(c.bg), image.Point{}, draw.Src)
+	if c.r == ' ' {
+		return
+	}
+	face := basicfont.Face7x13
+	d := font.Drawer{
+		Dst:  t.fb.SubImage(r).(*image.RGBA),
+		Src:  image.NewUniform(c.fg),
+		Face: face,
+		Dot:  fixed.P(r.Min.X+(t.cw-face.Advance)/2, r.Min.Y+(t.ch-face.Height)/2+face.Ascent),
+	}
+	d.DrawString(string(c.r))
+}
+
+// clear blanks columns x0 to x1 of row y with the current background.
+func (t *Terminal) clear(y, x0, x1 int) {
+	x0, x1 = max(x0, 0), min(x1, t.cols)
+	if x0 >= x1 {
+		return
+	}
+	c := cell{' ', t.pen.fg, t.pen.bg}
+	row := t.cells[y*t.cols : (y+1)*t.cols]
+	for x := x0; x < x1; x++ {
+		row[x] = c
+	}
+	r := image.Rect(x0*t.cw, y*t.ch, x1*t.cw, (y+1)*t.ch)
+	draw.Draw(t.fb, r, image.NewUniform(c.bg), image.Point{}, draw.Src)
+}
+
+// scrollUp moves the screen up n lines, blanking the lines at the bottom.
+func (t *Terminal) scrollUp(n int) {
+	n = min(n, t.rows)
+	copy(t.cells, t.cells[n*t.cols:])
+	copy(t.fb.Pix, t.fb.Pix[n*t.ch*t.fb.Stride:])
+	for y := t.rows - n; y < t.rows; y++ {
+		t.clear(y, 0, t.cols)
+	}
+}
+
+// scrollDown moves the screen down n lines, blanking the lines at the top.
+func (t *Terminal) scrollDown(n int) {
+	n = min(n, t.rows)
+	copy(t.cells[n*t.cols:], t.cells)
+	copy(t.fb.Pix[n*t.ch*t.fb.Stride:], t.fb.Pix)
+	for y := 0; y < n; y++ {
+		t.clear(y, 0, t.cols)
+	}
+}
+
+// index moves the cursor down a line, scrolling at the bottom.
+func (t *Terminal) index() {
+	t.wrap = false
+	if t.y == t.rows-1 {
+		t.scrollUp(1)
+		return
+	}
+	t.y++
+}
+
+// reverseIndex moves the cursor up a line, scrolling at the top.
+func (t *Terminal) reverseIndex() {
+	t.wrap = false
+	if t.y == 0 {
+		t.scrollDown(1)
+		return
+	}
+	t.y--
+}
+
+// moveTo moves the cursor to column x and row y, within the screen.
+func (t *Terminal) moveTo(x, y int) {
+	t.x = min(max(x, 0), t.cols-1)
+	t.y = min(max(y, 0), t.rows-1)
+	t.wrap = false
+}
+
+// sixel draws the sixel image of a DCS string at the cursor. With sixel
+// scrolling, the screen scrolls up if the image reaches below it and the
+// cursor moves to the start of the line below the image, or with mode
+// 8452 just after it on its last line. Without sixel scrolling (DECSDM),
+// the image is drawn at the top left corner and the cursor stays.
+func (t *Terminal) sixel(data []byte) {
+	dec := sixel.NewDecoder(bytes.NewReader(append(append([]byte("\x1bP"), data...), "\x1b\\"...)))
+	dec.Lenient = true
+	var img image.Image
+	dec.Decode(&img)
+	if img == nil {
+		return
+	}
+	b := img.Bounds()
+	if !t.sixelScrolling {
+		draw.Draw(t.fb, b, img, b.Min, draw.Over)
+		return
+	}
+	lines := max((b.Dy()+t.ch-1)/t.ch, 1)
+	if over := t.y + lines - t.rows; over > 0 {
+		t.scrollUp(over)
+		t.y -= over
+	}
+	at := image.Pt(t.x*t.cw, t.y*t.ch)
+	// the part of the image above the screen has scrolled off
+	draw.Draw(t.fb, b.Sub(b.Min).Add(at), img, b.Min, draw.Over)
+	last := max(t.y+lines-1, 0)
+	if t.sixelCursorRight {
+		t.moveTo(t.x+(b.Dx()+t.cw-1)/t.cw, last)
+		return
+	}
+	t.moveTo(0, last)
+	t.index()
+}
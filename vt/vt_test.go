@@ -0,0 +1,197 @@
+package vt
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sixel"
+)
+
+var update = flag.Bool("update", false, "update the golden images in testdata")
+
+// golden compares the screen of term with testdata/name.png.
+func golden(t *testing.T, term *Terminal, name string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".png")
+	if *update {
+		var b bytes.Buffer
+		if err := png.Encode(&b, term.Image()); err != nil {
+			t.Fatalf("png.Encode returned error: %v", err)
+		}
+		if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("png.Decode returned error: %v", err)
+	}
+	if x, y, ok := sameImage(term.Image(), want); !ok {
+		t.Fatalf("screen differs from %s at (%d, %d); run go test -update to accept it", path, x, y)
+	}
+}
+
+// sameImage reports whether a and b have the same bounds and colors, and
+// else where they first differ.
+func sameImage(a, b image.Image) (int, int, bool) {
+	r := a.Bounds()
+	if r != b.Bounds() {
+		return r.Min.X, r.Min.Y, false
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.RGBAModel.Convert(a.At(x, y)) != color.RGBAModel.Convert(b.At(x, y)) {
+				return x, y, false
+			}
+		}
+	}
+	return 0, 0, true
+}
+
+func encode(t *testing.T, img image.Image) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := sixel.NewEncoder(&b).Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	return b.String()
+}
+
+func uniform(c color.Color, w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b, a := c.RGBA()
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+	}
+	return img
+}
+
+func TestText(t *testing.T) {
+	term := New(20, 5, 8, 16)
+	term.Write([]byte("hello\r\nwor\x1b[31mld\x1b[0m\r\n\x1b[7m rev \x1b[m\x1b[3;16Hend\x1b[1;3H\x1b[K"))
+	if got, want := term.Text(), "he\nworld\n rev           end"; got != want {
+		t.Fatalf("text %q, want %q", got, want)
+	}
+	if x, y := term.Cursor(); x != 2 || y != 0 {
+		t.Fatalf("cursor at (%d, %d), want (2, 0)", x, y)
+	}
+	golden(t, term, "text")
+}
+
+func TestWrapAndScroll(t *testing.T) {
+	term := New(4, 2, 8, 16)
+	term.NewLine = true
+	term.Write([]byte("abcdefgh\nij"))
+	if got, want := term.Text(), "efgh\nij"; got != want {
+		t.Fatalf("text %q, want %q", got, want)
+	}
+	term.Write([]byte("\x1b[?7l\x1b[2J\x1b[Habcdef"))
+	if got, want := term.Text(), "abcf"; got != want {
+		t.Fatalf("text without autowrap %q, want %q", got, want)
+	}
+}
+
+func TestUTF8(t *testing.T) {
+	term := New(10, 1, 8, 16)
+	term.Write([]byte("a\xc3"))
+	term.Write([]byte("\xa9b\xff\xe3\x81\x82"))
+	if got, want := term.Text(), "aéb�あ"; got != want {
+		t.Fatalf("text %q, want %q", got, want)
+	}
+}
+
+// TestAnimation draws frames the way gosgif and gosvideo do: lines are
+// reserved for the image, the cursor is saved, and each frame is drawn
+// after restoring it.
+func TestAnimation(t *testing.T) {
+	term := New(12, 6, 8, 16)
+	term.NewLine = true
+	term.Write([]byte("$ gosgif\n"))
+	term.Write([]byte("\n\n\n\x1b[3A\x1b[s"))
+	term.Write([]byte(encode(t, uniform(color.RGBA{0xff, 0, 0, 0xff}, 40, 36))))
+	term.Write([]byte("\x1b[u"))
+	term.Write([]byte(encode(t, uniform(color.RGBA{0, 0, 0xff, 0xff}, 24, 20))))
+	if x, y := term.Cursor(); x != 0 || y != 3 {
+		t.Fatalf("cursor at (%d, %d), want (0, 3)", x, y)
+	}
+	golden(t, term, "animation")
+}
+
+func TestSixelScrolling(t *testing.T) {
+	term := New(8, 4, 8, 16)
+	term.NewLine = true
+	term.Write([]byte("1\n2\n3\n4"))
+	term.Write([]byte(encode(t, uniform(color.RGBA{0, 0xcd, 0, 0xff}, 20, 40))))
+	// the 3 lines of the image and the cursor below it scroll the screen
+	if got, want := term.Text(), "4"; got != want {
+		t.Fatalf("text %q, want %q", got, want)
+	}
+	if x, y := term.Cursor(); x != 0 || y != 3 {
+		t.Fatalf("cursor at (%d, %d), want (0, 3)", x, y)
+	}
+	golden(t, term, "scrolling")
+
+	// DECSDM draws at the top left and leaves the cursor alone
+	term.Write([]byte("\x1b[?80h\x1b[2;3H"))
+	term.Write([]byte(encode(t, uniform(color.RGBA{0xff, 0xff, 0, 0xff}, 10, 10))))
+	if x, y := term.Cursor(); x != 2 || y != 1 {
+		t.Fatalf("cursor with DECSDM at (%d, %d), want (2, 1)", x, y)
+	}
+	if got := term.Image().RGBAAt(5, 5); got != (color.RGBA{0xff, 0xff, 0, 0xff}) {
+		t.Fatalf("pixel with DECSDM %v", got)
+	}
+
+	// mode 8452 leaves the cursor after the image
+	term.Write([]byte("\x1b[?80l\x1b[?8452h\x1b[H"))
+	term.Write([]byte(encode(t, uniform(color.RGBA{0xcd, 0, 0, 0xff}, 20, 20))))
+	if x, y := term.Cursor(); x != 3 || y != 1 {
+		t.Fatalf("cursor with mode 8452 at (%d, %d), want (3, 1)", x, y)
+	}
+}
+
+// TestEncoderTiles checks that tiles written by the encoder are placed to
+// form the whole image.
+func TestEncoderTiles(t *testing.T) {
+	const cw, ch = 10, 20
+	src := image.NewNRGBA(image.Rect(0, 0, 70, 50))
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 70; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x / 10 * 36), uint8(y / 10 * 50), 0x80, 0xff})
+		}
+	}
+
+	whole := New(10, 6, cw, ch)
+	whole.Write([]byte(encode(t, src)))
+
+	var b bytes.Buffer
+	enc := sixel.NewEncoder(&b)
+	enc.TileWidth, enc.TileHeight = 30, 20
+	enc.CellWidth, enc.CellHeight = cw, ch
+	if err := enc.Encode(src); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	if n := strings.Count(b.String(), "\x1bP"); n != 9 {
+		t.Fatalf("%d tiles, want 9", n)
+	}
+	tiled := New(10, 6, cw, ch)
+	tiled.NewLine = true
+	tiled.Write(b.Bytes())
+
+	r := src.Bounds()
+	if x, y, ok := sameImage(tiled.Image().SubImage(r), whole.Image().SubImage(r)); !ok {
+		t.Fatalf("tiled image differs at (%d, %d)", x, y)
+	}
+}
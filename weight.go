@@ -0,0 +1,138 @@
+package sixel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// weights returns the palette weights for an image with bounds b, or nil if
+// none are set.
+func (e *Encoder) weights(b image.Rectangle) ([]float32, error) {
+	if e.Weights != nil {
+		if len(e.Weights) != b.Dx()*b.Dy() {
+			return nil, fmt.Errorf("sixel: %d weights for a %dx%d image", len(e.Weights), b.Dx(), b.Dy())
+		}
+		return e.Weights, nil
+	}
+	if e.WeightMask != nil {
+		return maskWeights(e.WeightMask, b), nil
+	}
+	return nil, nil
+}
+
+// maskWeights converts the alpha channel of mask over b into weights in
+// [0, 1]. Pixels outside the mask get no weight.
+func maskWeights(mask image.Image, b image.Rectangle) []float32 {
+	weights := make([]float32, b.Dx()*b.Dy())
+	if a, ok := mask.(*image.Alpha); ok {
+		r := b.Intersect(a.Bounds())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			so := a.PixOffset(r.Min.X, y)
+			do := (y-b.Min.Y)*b.Dx() + r.Min.X - b.Min.X
+			for x := r.Min.X; x < r.Max.X; x++ {
+				weights[do] = float32(a.Pix[so]) / 0xFF
+				so++
+				do++
+			}
+		}
+		return weights
+	}
+	r := b.Intersect(mask.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		do := (y-b.Min.Y)*b.Dx() + r.Min.X - b.Min.X
+		for x := r.Min.X; x < r.Max.X; x++ {
+			_, _, _, alpha := mask.At(x, y).RGBA()
+			weights[do] = float32(alpha) / 0xFFFF
+			do++
+		}
+	}
+	return weights
+}
+
+// appendWeightedSample returns sample followed by about as many pixels
+// again, drawn from rgba by systematic sampling in proportion to weights.
+// Fully transparent pixels are never drawn.
+func appendWeightedSample(sample, rgba *image.RGBA, weights []float32) *image.RGBA {
+	b := rgba.Bounds()
+	n := sample.Bounds().Dx() * sample.Bounds().Dy()
+	var total float64
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		so := rgba.PixOffset(b.Min.X, y) + 3
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if w := weights[i]; w > 0 && rgba.Pix[so] != 0 {
+				total += float64(w)
+			}
+			so += 4
+			i++
+		}
+	}
+	if total == 0 || n == 0 {
+		return sample
+	}
+	pix := make([]uint8, 0, 2*4*n+4)
+	for y := 0; y < sample.Bounds().Dy(); y++ {
+		so := sample.PixOffset(sample.Bounds().Min.X, sample.Bounds().Min.Y+y)
+		pix = append(pix, sample.Pix[so:so+4*sample.Bounds().Dx()]...)
+	}
+	step := total / float64(n)
+	acc := step / 2
+	i = 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		so := rgba.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if w := weights[i]; w > 0 && rgba.Pix[so+3] != 0 {
+				for acc += float64(w); acc >= step; acc -= step {
+					pix = append(pix, rgba.Pix[so:so+4]...)
+				}
+			}
+			so += 4
+			i++
+		}
+	}
+	return &image.RGBA{Pix: pix, Stride: len(pix), Rect: image.Rect(0, 0, len(pix)/4, 1)}
+}
+
+// EdgeWeights returns palette weights for img that emphasize edges and
+// local contrast, such as text, outlines and facial features, for use as
+// Encoder.Weights. The weights are the Sobel gradient magnitude of the
+// luminance, normalized to [0, 1].
+func EdgeWeights(img image.Image) []float32 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rgba := toRGBA(img)
+	lum := make([]float32, w*h)
+	for y := 0; y < h; y++ {
+		so := rgba.PixOffset(b.Min.X, b.Min.Y+y)
+		for x := 0; x < w; x++ {
+			p := rgba.Pix[so : so+4 : so+4]
+			lum[y*w+x] = 0.299*float32(p[0]) + 0.587*float32(p[1]) + 0.114*float32(p[2])
+			so += 4
+		}
+	}
+	at := func(x, y int) float32 {
+		x = min(max(x, 0), w-1)
+		y = min(max(y, 0), h-1)
+		return lum[y*w+x]
+	}
+	weights := make([]float32, w*h)
+	var peak float32
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) -
+				at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) -
+				at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			m := float32(math.Sqrt(float64(gx*gx + gy*gy)))
+			weights[y*w+x] = m
+			peak = max(peak, m)
+		}
+	}
+	if peak > 0 {
+		for i := range weights {
+			weights[i] /= peak
+		}
+	}
+	return weights
+}
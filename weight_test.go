@@ -0,0 +1,93 @@
+package sixel
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+var weightTestColor = color.NRGBA{0xB0, 0x30, 0, 0xFF}
+
+// weightTestImage is a red/green gradient covering the whole plane with a
+// small patch of weightTestColor in the middle.
+func weightTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 0, 0xFF})
+		}
+	}
+	for y := 100; y < 104; y++ {
+		for x := 100; x < 104; x++ {
+			img.Set(x, y, weightTestColor)
+		}
+	}
+	return img
+}
+
+// patchError encodes img and returns the squared distance between the
+// decoded patch and weightTestColor.
+func patchError(t *testing.T, enc *Encoder, out *bytes.Buffer, img image.Image) int {
+	t.Helper()
+	out.Reset()
+	if err := enc.Encode(img); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	var decoded image.Image
+	if err := NewDecoder(out).Decode(&decoded); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	c := color.NRGBAModel.Convert(decoded.At(101, 101)).(color.NRGBA)
+	dr := int(c.R) - int(weightTestColor.R)
+	dg := int(c.G) - int(weightTestColor.G)
+	db := int(c.B) - int(weightTestColor.B)
+	return dr*dr + dg*dg + db*db
+}
+
+func TestEncodeWeightMask(t *testing.T) {
+	img := weightTestImage()
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+	enc.Colors = 5
+	plain := patchError(t, enc, &out, img)
+
+	mask := image.NewAlpha(img.Bounds())
+	for y := 100; y < 104; y++ {
+		for x := 100; x < 104; x++ {
+			mask.SetAlpha(x, y, color.Alpha{0xFF})
+		}
+	}
+	enc.WeightMask = mask
+	weighted := patchError(t, enc, &out, img)
+	if weighted >= plain || weighted > 3*4*4 {
+		t.Fatalf("weighted patch error %d, unweighted %d", weighted, plain)
+	}
+}
+
+func TestEncodeWeightsLength(t *testing.T) {
+	enc := NewEncoder(&bytes.Buffer{})
+	enc.Weights = make([]float32, 3)
+	if err := enc.Encode(weightTestImage()); err == nil {
+		t.Fatalf("Encode accepted weights of the wrong length")
+	}
+}
+
+func TestEdgeWeights(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 4; x < 8; x++ {
+			img.SetGray(x, y, color.Gray{0xFF})
+		}
+	}
+	weights := EdgeWeights(img)
+	if len(weights) != 64 {
+		t.Fatalf("got %d weights, want 64", len(weights))
+	}
+	if w := weights[3*8+0]; w != 0 {
+		t.Fatalf("flat region has weight %v", w)
+	}
+	if w := weights[3*8+4]; w != 1 {
+		t.Fatalf("edge has weight %v, want 1", w)
+	}
+}